@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"go/ast"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/urfave/cli"
@@ -17,17 +22,33 @@ type cliOpts struct {
 	ProtoFile string
 	JSONTag   bool
 	Decorator string
-}
 
-//Run runs tproto
-func Run(version string) {
-	app := cli.NewApp()
-	app.Name = "tproto"
-	app.Version = version
-	app.Usage = "Parse golang data structure into proto3."
+	UnsignedAsSigned   bool
+	TimeWellKnownTypes bool
+	Presence           string
+	OmitEmptyPresence  bool
+	Embedding          string
+	Namespace          string
+	SnakeCaseFields    bool
+	CamelCaseMessages  bool
+	UpperSnakeEnums    bool
+	ValidateRules      bool
+	WriteTags          bool
+	LockFile           string
+	FileOptions        string
+	GoPackage          string
+	GoPackageSuffix    string
+	JavaPackage        string
+	CsharpNamespace    string
+	OutDir             string
+	Syntax             string
 
-	opts := new(cliOpts)
-	app.Flags = []cli.Flag{
+	Against string
+}
+
+// parseFlags returns flags of parsing, shared by tproto and its commands
+func parseFlags(opts *cliOpts) []cli.Flag {
+	return []cli.Flag{
 		cli.StringFlag{
 			Name:        "package, p",
 			Usage:       "package path `PKG`",
@@ -41,7 +62,7 @@ func Run(version string) {
 		},
 		cli.StringFlag{
 			Name:        "decorator, d",
-			Usage:       "(any-of required) parse package with decorator `DECORATOR`",
+			Usage:       "(any-of required) parse package with decorator `DECORATOR`, decorated interfaces are services",
 			Destination: &opts.Decorator,
 		},
 		cli.StringFlag{
@@ -51,7 +72,7 @@ func Run(version string) {
 		},
 		cli.StringFlag{
 			Name:        "proto-file, pf",
-			Usage:       "load messages from proto file `PF`",
+			Usage:       "load messages and enums from proto file `PF`, field numbers and string enum values of loaded ones are kept",
 			Destination: &opts.ProtoFile,
 		},
 		cli.BoolFlag{
@@ -59,68 +80,443 @@ func Run(version string) {
 			Usage:       "don't ignore json tag",
 			Destination: &opts.JSONTag,
 		},
+		cli.BoolFlag{
+			Name:        "unsigned-as-signed, uas",
+			Usage:       "map unsigned integers to signed proto types (legacy output)",
+			Destination: &opts.UnsignedAsSigned,
+		},
+		cli.BoolFlag{
+			Name:        "well-known-time, wkt",
+			Usage:       "map time.Time and time.Duration to google.protobuf.Timestamp and google.protobuf.Duration",
+			Destination: &opts.TimeWellKnownTypes,
+		},
+		cli.StringFlag{
+			Name:        "presence, ps",
+			Usage:       "render pointer scalar and enum fields as 'optional' fields or 'wrapper' types (scalars only) `MODE`",
+			Destination: &opts.Presence,
+		},
+		cli.BoolFlag{
+			Name:        "omitempty-presence, oep",
+			Usage:       "treat scalar fields tagged with json omitempty as pointer fields",
+			Destination: &opts.OmitEmptyPresence,
+		},
+		cli.StringFlag{
+			Name:        "embedding, em",
+			Usage:       "render embedded structs by 'flatten' (default) or 'compose' `MODE`",
+			Destination: &opts.Embedding,
+		},
+		cli.StringFlag{
+			Name:        "syntax, sx",
+			Usage:       "render 'proto3' (default), 'proto2' or 'editions' files, proto2 fields tagged with required:\"true\" are required `SYNTAX`",
+			Destination: &opts.Syntax,
+		},
+		cli.StringFlag{
+			Name:        "namespace, ns",
+			Usage:       "disambiguate types of other packages by package name 'prefix' or 'nested' messages `MODE`",
+			Destination: &opts.Namespace,
+		},
+		cli.StringSliceFlag{
+			Name:  "rename, rn",
+			Usage: "rename message or enum of golang type, e.g. github.com/foo/bar.Account=BarAccount `GOTYPE=NAME`",
+		},
+		cli.BoolFlag{
+			Name:        "snake-case-fields, scf",
+			Usage:       "render snake_case field names, with json_name options if they differ from JSON names",
+			Destination: &opts.SnakeCaseFields,
+		},
+		cli.BoolFlag{
+			Name:        "camel-case-messages, ccm",
+			Usage:       "render CamelCase message and enum names without underscores",
+			Destination: &opts.CamelCaseMessages,
+		},
+		cli.BoolFlag{
+			Name:        "upper-snake-enums, use",
+			Usage:       "render UPPER_SNAKE enum values prefixed by enum names",
+			Destination: &opts.UpperSnakeEnums,
+		},
+		cli.BoolFlag{
+			Name:        "validate, vd",
+			Usage:       "translate validate tags of go-playground validator into protovalidate options, untranslated rules are warned",
+			Destination: &opts.ValidateRules,
+		},
+		cli.StringSliceFlag{
+			Name:  "dynamic-type, dt",
+			Usage: "map dynamic type (interface{}, map[string]interface{}, json.RawMessage) to proto type, an empty proto type ignores it `GOTYPE=PROTOTYPE`",
+		},
+	}
+}
+
+// renderFlags returns flags of rendering and writing, which only tproto accepts
+func renderFlags(opts *cliOpts) []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:        "lock-file, lf",
+			Usage:       "keep field numbers and string enum values in lockfile `LF`, which is read if exists and updated after generation, e.g. " + tproto.DefaultLockFile + " (JSON) or " + tproto.DefaultLockFile + ".yaml",
+			Destination: &opts.LockFile,
+		},
+		cli.StringFlag{
+			Name:        "file-options, fop",
+			Usage:       "load file options from config file `FILE` (JSON, or YAML if ends with .yaml or .yml), flags below override it",
+			Destination: &opts.FileOptions,
+		},
+		cli.StringFlag{
+			Name:        "go-package, gp",
+			Usage:       "go_package option `GP` of the parsed package, derived from the import path and --go-package-suffix by default",
+			Destination: &opts.GoPackage,
+		},
+		cli.StringFlag{
+			Name:        "go-package-suffix, gps",
+			Usage:       "suffix appended to the import path to derive go_package (default: \"" + tproto.DefaultGoPackageSuffix + "\") `SUFFIX`",
+			Destination: &opts.GoPackageSuffix,
+		},
+		cli.StringFlag{
+			Name:        "java-package, jp",
+			Usage:       "java_package option `JP`",
+			Destination: &opts.JavaPackage,
+		},
+		cli.StringFlag{
+			Name:        "csharp-namespace, csn",
+			Usage:       "csharp_namespace option `CSN`",
+			Destination: &opts.CsharpNamespace,
+		},
+		cli.StringSliceFlag{
+			Name:  "file-option, fo",
+			Usage: "file option, including custom ones, VALUE is a proto constant whose strings are quoted, e.g. optimize_for=SPEED `NAME=VALUE`",
+		},
+		cli.BoolFlag{
+			Name:        "write-tags, wt",
+			Usage:       "write field numbers back into golang source as tproto tags",
+			Destination: &opts.WriteTags,
+		},
+		cli.StringFlag{
+			Name:        "out-dir, o",
+			Usage:       "render one proto file per golang package into directory tree `DIR` mirroring import paths, proto packages are derived from import paths",
+			Destination: &opts.OutDir,
+		},
 	}
+}
+
+// Run runs tproto
+func Run(version string) {
+	app := cli.NewApp()
+	app.Name = "tproto"
+	app.Version = version
+	app.Usage = "Parse golang data structure into proto3."
+
+	opts := new(cliOpts)
+	app.Flags = append(parseFlags(opts), renderFlags(opts)...)
 	app.Action = func(c *cli.Context) (err error) {
 		if c.NArg() > 0 {
 			opts.TypeExprs = strings.Join(c.Args(), ",")
 		}
-		if opts.ProtoPkg == "" || (opts.TypeExprs == "" && opts.Decorator == "") {
+		if (opts.ProtoPkg == "" && opts.OutDir == "") || (opts.TypeExprs == "" && opts.Decorator == "") {
 			cli.ShowAppHelp(c)
 			return
 		}
 
-		parser := tproto.NewParser()
-		parserOpts := tproto.DefaultParserOptions
-		parserOpts.IgnoreJSONTag = !opts.JSONTag
-		parser.Options(parserOpts)
-
-		if opts.ProtoFile != "" {
-			err = parser.LoadProtoFile(opts.ProtoFile)
-			if err != nil {
-				msg := fmt.Sprintf("failed to load proto file %s: %s", opts.ProtoFile, err)
-				err = cli.NewExitError(msg, 1)
-				return
-			}
-		}
-
-		exprs := make([]string, 0, 2)
-		for _, expr := range strings.Split(opts.TypeExprs, ",") {
-			expr = strings.TrimSpace(expr)
-			if expr == "" {
-				continue
-			}
-			exprs = append(exprs, expr)
+		parser, err := parse(c, opts)
+		if err != nil {
+			return
 		}
-		if opts.Decorator != "" {
-			pkg, e := tspec.NewParser().Import(opts.PkgPath)
+		if opts.WriteTags {
+			files, e := parser.WriteFieldTags()
 			if e != nil {
-				msg := fmt.Sprintf("failed to import pkg '%s': %s", opts.PkgPath, e)
-				err = cli.NewExitError(msg, 1)
-				return
-			}
-			objs, e := tspec.ParsePkgWithDecorator(pkg, opts.Decorator)
-			if e != nil {
-				msg := fmt.Sprintf("failed to parse pkg with decorator, %s", e)
-				err = cli.NewExitError(msg, 1)
+				err = cli.NewExitError(e.Error(), 1)
 				return
 			}
-			for k := range objs {
-				exprs = append(exprs, k)
+			for _, f := range files {
+				fmt.Fprintf(os.Stderr, "wrote field tags to %s\n", f)
 			}
 		}
-
-		for _, expr := range exprs {
-			_, err = parser.Parse(opts.PkgPath, expr)
+		if opts.LockFile != "" {
+			err = parser.WriteLockFile(opts.LockFile)
 			if err != nil {
-				msg := fmt.Sprintf("failed to parse type expr %s: %s", expr, err)
+				msg := fmt.Sprintf("failed to write lockfile %s: %s", opts.LockFile, err)
 				err = cli.NewExitError(msg, 1)
 				return
 			}
 		}
-
+		if opts.OutDir != "" {
+			err = writeProtoFiles(parser, opts.OutDir)
+			return
+		}
 		fmt.Println(parser.RenderProto(opts.ProtoPkg).String())
 		return
 	}
+	app.Commands = []cli.Command{
+		{
+			Name:      "check",
+			Usage:     "report wire and JSON breaking changes against an existing proto file in JSON",
+			ArgsUsage: "[EXPRS...]",
+			Flags: append(parseFlags(opts),
+				cli.StringFlag{
+					Name:        "lock-file, lf",
+					Usage:       "number fields and string enum values by lockfile `LF` as generation does, which is only read",
+					Destination: &opts.LockFile,
+				},
+				cli.StringFlag{
+					Name:        "against, a",
+					Usage:       "(required) existing proto file `FILE`",
+					Destination: &opts.Against,
+				}),
+			Action: func(c *cli.Context) (err error) {
+				if c.NArg() > 0 {
+					opts.TypeExprs = strings.Join(c.Args(), ",")
+				}
+				if opts.Against == "" || (opts.TypeExprs == "" && opts.Decorator == "") {
+					cli.ShowCommandHelp(c, "check")
+					return
+				}
+
+				existing, err := tproto.ParseProtoFile(opts.Against)
+				if err != nil {
+					msg := fmt.Sprintf("failed to parse proto file %s: %s", opts.Against, err)
+					err = cli.NewExitError(msg, 1)
+					return
+				}
+				parser, err := parse(c, opts)
+				if err != nil {
+					return
+				}
+				report := parser.Check(existing)
+				data, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					err = cli.NewExitError(err.Error(), 1)
+					return
+				}
+				fmt.Println(string(data))
+				if report.Breaking {
+					err = cli.NewExitError("", 1)
+				}
+				return
+			},
+		},
+	}
 
 	app.Run(os.Args)
 }
+
+// parse parses requested types with options
+func parse(c *cli.Context, opts *cliOpts) (parser *tproto.Parser, err error) {
+	parser = tproto.NewParser()
+	parserOpts := tproto.DefaultParserOptions
+	parserOpts.IgnoreJSONTag = !opts.JSONTag
+	parserOpts.UnsignedAsSigned = opts.UnsignedAsSigned
+	parserOpts.TimeWellKnownTypes = opts.TimeWellKnownTypes
+	parserOpts.OmitEmptyPresence = opts.OmitEmptyPresence
+	parserOpts.SnakeCaseFields = opts.SnakeCaseFields
+	parserOpts.CamelCaseMessages = opts.CamelCaseMessages
+	parserOpts.UpperSnakeEnumValues = opts.UpperSnakeEnums
+	parserOpts.ValidateRules = opts.ValidateRules
+	parserOpts.PackageFiles = opts.OutDir != ""
+	parserOpts.Decorator = opts.Decorator
+	switch presence := tproto.PresenceMode(opts.Presence); presence {
+	case tproto.PresenceNone, tproto.PresenceOptional, tproto.PresenceWrapper:
+		parserOpts.Presence = presence
+	default:
+		msg := fmt.Sprintf("invalid presence mode %s", opts.Presence)
+		err = cli.NewExitError(msg, 1)
+		return
+	}
+	switch embedding := tproto.EmbedMode(opts.Embedding); embedding {
+	case "":
+	case tproto.EmbedFlatten, tproto.EmbedCompose:
+		parserOpts.Embedding = embedding
+	default:
+		msg := fmt.Sprintf("invalid embedding mode %s", opts.Embedding)
+		err = cli.NewExitError(msg, 1)
+		return
+	}
+	switch syntax := tproto.Syntax(opts.Syntax); syntax {
+	case "":
+	case tproto.SyntaxProto3, tproto.SyntaxProto2, tproto.SyntaxEditions:
+		parserOpts.Syntax = syntax
+	default:
+		msg := fmt.Sprintf("invalid syntax %s", opts.Syntax)
+		err = cli.NewExitError(msg, 1)
+		return
+	}
+	switch namespace := tproto.NamespaceMode(opts.Namespace); namespace {
+	case tproto.NamespaceNone, tproto.NamespacePrefix, tproto.NamespaceNested:
+		parserOpts.Namespace = namespace
+	default:
+		msg := fmt.Sprintf("invalid namespace mode %s", opts.Namespace)
+		err = cli.NewExitError(msg, 1)
+		return
+	}
+	renames := make(map[string]string)
+	for _, kv := range c.StringSlice("rename") {
+		strs := strings.SplitN(kv, "=", 2)
+		if len(strs) != 2 || strings.TrimSpace(strs[1]) == "" {
+			msg := fmt.Sprintf("invalid rename %s, want GOTYPE=NAME", kv)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+		renames[strings.TrimSpace(strs[0])] = strings.TrimSpace(strs[1])
+	}
+	parserOpts.Renames = renames
+	dynamicTypes := tproto.DefaultDynamicTypes()
+	for _, kv := range c.StringSlice("dynamic-type") {
+		strs := strings.SplitN(kv, "=", 2)
+		if len(strs) != 2 {
+			msg := fmt.Sprintf("invalid dynamic type %s, want GOTYPE=PROTOTYPE", kv)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+		goType := strings.TrimSpace(strs[0])
+		switch goType {
+		case tproto.DynamicInterface, tproto.DynamicMap, tproto.DynamicRawJSON:
+			dynamicTypes[goType] = strings.TrimSpace(strs[1])
+		default:
+			msg := fmt.Sprintf("invalid dynamic type %s", goType)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+	}
+	parserOpts.DynamicTypes = dynamicTypes
+	parserOpts.FileOptions, err = fileOptions(c, opts)
+	if err != nil {
+		return
+	}
+	parser.Options(parserOpts)
+
+	if opts.ProtoFile != "" {
+		err = parser.LoadProtoFile(opts.ProtoFile)
+		if err != nil {
+			msg := fmt.Sprintf("failed to load proto file %s: %s", opts.ProtoFile, err)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+	}
+	if _, e := os.Stat(opts.LockFile); opts.LockFile != "" && e == nil {
+		err = parser.LoadLockFile(opts.LockFile)
+		if err != nil {
+			msg := fmt.Sprintf("failed to load lockfile %s: %s", opts.LockFile, err)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+	}
+
+	exprs := make([]string, 0, 2)
+	var services []string
+	for _, expr := range strings.Split(opts.TypeExprs, ",") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+		exprs = append(exprs, expr)
+	}
+	if opts.Decorator != "" {
+		pkg, e := tspec.NewParser().Import(opts.PkgPath)
+		if e != nil {
+			msg := fmt.Sprintf("failed to import pkg '%s': %s", opts.PkgPath, e)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+		objs, e := tspec.ParsePkgWithDecorator(pkg, opts.Decorator)
+		if e != nil {
+			msg := fmt.Sprintf("failed to parse pkg with decorator, %s", e)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+		for k, obj := range objs {
+			// decorated interfaces are services
+			if ts, ok := obj.Decl.(*ast.TypeSpec); ok {
+				if _, ok := ts.Type.(*ast.InterfaceType); ok {
+					services = append(services, k)
+					continue
+				}
+			}
+			exprs = append(exprs, k)
+		}
+	}
+
+	for _, expr := range exprs {
+		_, err = parser.Parse(opts.PkgPath, expr)
+		if err != nil {
+			msg := fmt.Sprintf("failed to parse type expr %s: %s", expr, err)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+	}
+	for _, expr := range services {
+		_, err = parser.ParseService(opts.PkgPath, expr)
+		if err != nil {
+			msg := fmt.Sprintf("failed to parse service %s: %s", expr, err)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+	}
+	for _, w := range parser.ValidateWarnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	return
+}
+
+// fileOptions returns file options loaded from config file and overridden by flags
+func fileOptions(c *cli.Context, opts *cliOpts) (fileOpts tproto.FileOptions, err error) {
+	fileOpts = tproto.DefaultParserOptions.FileOptions
+	if opts.FileOptions != "" {
+		fileOpts, err = tproto.LoadFileOptions(opts.FileOptions)
+		if err != nil {
+			msg := fmt.Sprintf("failed to load file options %s: %s", opts.FileOptions, err)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+	}
+	if opts.GoPackage != "" {
+		fileOpts.GoPackage = opts.GoPackage
+	}
+	if opts.GoPackageSuffix != "" {
+		fileOpts.GoPackageSuffix = opts.GoPackageSuffix
+	}
+	if opts.JavaPackage != "" {
+		fileOpts.JavaPackage = opts.JavaPackage
+	}
+	if opts.CsharpNamespace != "" {
+		fileOpts.CsharpNamespace = opts.CsharpNamespace
+	}
+	options := make(map[string]string)
+	for k, v := range fileOpts.Options {
+		options[k] = v
+	}
+	for _, kv := range c.StringSlice("file-option") {
+		strs := strings.SplitN(kv, "=", 2)
+		if len(strs) != 2 || strings.TrimSpace(strs[0]) == "" {
+			msg := fmt.Sprintf("invalid file option %s, want NAME=VALUE", kv)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+		options[strings.TrimSpace(strs[0])] = strings.TrimSpace(strs[1])
+	}
+	fileOpts.Options = options
+	return
+}
+
+// writeProtoFiles writes proto files of each golang package into dir
+func writeProtoFiles(parser *tproto.Parser, dir string) (err error) {
+	files, err := parser.RenderProtoFiles()
+	if err != nil {
+		err = cli.NewExitError(err.Error(), 1)
+		return
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, files[name].Bytes(), 0644)
+		}
+		if err != nil {
+			msg := fmt.Sprintf("failed to write proto file %s: %s", path, err)
+			err = cli.NewExitError(msg, 1)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "wrote %s\n", path)
+	}
+	return
+}
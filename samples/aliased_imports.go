@@ -0,0 +1,29 @@
+package samples
+
+import (
+	gocontext "context"
+	js "encoding/json"
+	stdtime "time"
+
+	"github.com/wy-z/tproto/samples/context"
+	"github.com/wy-z/tproto/samples/time"
+)
+
+// StructWithAliasedImports defines struct with types of aliased imports and of a package
+// named time
+type StructWithAliasedImports struct {
+	CreatedAt stdtime.Time     `json:"created_at"`
+	Timeout   stdtime.Duration `json:"timeout"`
+	Raw       js.RawMessage    `json:"raw"`
+	Slot      time.Time        `json:"slot"`
+}
+
+// ServiceWithAliasedContext defines service taking context of an aliased import
+type ServiceWithAliasedContext interface {
+	GetUser(ctx gocontext.Context, req *GetUserRequest) (*User, error)
+}
+
+// ServiceWithLocalContext defines service taking context of a package named context
+type ServiceWithLocalContext interface {
+	GetUser(ctx context.Context, req *GetUserRequest) (*User, error)
+}
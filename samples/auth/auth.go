@@ -0,0 +1,23 @@
+package auth
+
+// Account defines auth account
+type Account struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+// Session defines auth session
+type Session struct {
+	Token     string   `json:"token" required:"true"`
+	AccountID string   `json:"account_id" required:"true"` // id of the account
+	Scopes    []string `json:"scopes"`
+}
+
+// SessionState defines auth session state
+type SessionState int
+
+// Session states
+const (
+	StateOpen SessionState = iota + 1
+	StateExpired
+)
@@ -0,0 +1,20 @@
+package billing
+
+// State defines account state
+type State int
+
+// Account states
+const (
+	StateOpen State = iota + 1
+	StateClosed
+)
+
+// Account defines billing account
+type Account struct {
+	ID      string `json:"id"`
+	Balance int64  `json:"balance"`
+	State   State  `json:"state"`
+	Limits  struct {
+		Daily int64 `json:"daily"`
+	} `json:"limits"`
+}
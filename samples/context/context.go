@@ -0,0 +1,7 @@
+// Package context defines types sharing names with the context package of golang
+package context
+
+// Context defines request context
+type Context struct {
+	TraceID string `json:"trace_id"`
+}
@@ -0,0 +1,8 @@
+// Package time defines types sharing names with the time package of golang
+package time
+
+// Time defines time slot
+type Time struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
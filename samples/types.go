@@ -1,6 +1,13 @@
 package samples
 
-import "time"
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/wy-z/tproto/samples/auth"
+	"github.com/wy-z/tproto/samples/billing"
+)
 
 // BasicTypes defines basic types
 type BasicTypes struct {
@@ -65,3 +72,507 @@ type StructWithInheritance struct {
 	*NormalStruct `json:"normal_struct"`
 	*StructWithCircularReference
 }
+
+// StructWithTimeTypes defines struct with time types
+type StructWithTimeTypes struct {
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt *time.Time      `json:"expires_at"`
+	Timeout   time.Duration   `json:"timeout"`
+	Intervals []time.Duration `json:"intervals"`
+}
+
+// StructWithPointerFields defines struct with pointer fields
+type StructWithPointerFields struct {
+	Name     *string  `json:"name"`
+	Age      *uint32  `json:"age"`
+	Score    *float64 `json:"score"`
+	Enabled  *bool    `json:"enabled"`
+	Nickname string   `json:"nickname,omitempty"`
+	Count    int      `json:"count"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// StructWithPointerEnumFields defines struct with pointer enum fields
+type StructWithPointerEnumFields struct {
+	Level  *Level `json:"level"`
+	Status Status `json:"status"`
+}
+
+// Status defines status enum
+type Status int
+
+// Status values
+const (
+	StatusActive Status = iota + 1
+	StatusInactive
+	StatusDeleted
+)
+
+// Priority defines priority enum with zero value
+type Priority uint8
+
+// Priority values
+const (
+	PriorityLow Priority = iota
+	PriorityMedium
+	PriorityHigh
+	PriorityDefault = PriorityMedium
+)
+
+// Color defines string enum
+type Color string
+
+// Color values
+const (
+	ColorRed   Color = "red"
+	ColorGreen Color = "green"
+	ColorBlue  Color = "blue"
+)
+
+// StructWithEnums defines struct with enums
+type StructWithEnums struct {
+	Status     Status           `json:"status"`
+	Priority   *Priority        `json:"priority"`
+	Colors     []Color          `json:"colors"`
+	ColorCodes map[string]Color `json:"color_codes"`
+}
+
+// Shape defines sealed interface implemented by Circle and Square
+type Shape interface {
+	isShape()
+}
+
+// Circle defines circle shape
+type Circle struct {
+	Radius float64 `json:"radius"`
+}
+
+func (Circle) isShape() {}
+
+// Square defines square shape
+type Square struct {
+	Side float64 `json:"side"`
+}
+
+func (*Square) isShape() {}
+
+// Animal defines sealed interface listing its implementations
+// @oneof Cat Dog
+type Animal interface {
+	Name() string
+}
+
+// Cat defines cat animal
+type Cat struct {
+	Lives int `json:"lives"`
+}
+
+// Name returns animal name
+func (Cat) Name() string { return "cat" }
+
+// Dog defines dog animal
+type Dog struct {
+	Breed string `json:"breed"`
+}
+
+// Name returns animal name
+func (Dog) Name() string { return "dog" }
+
+// StructWithOneof defines struct with sealed interfaces
+type StructWithOneof struct {
+	ID     int64   `json:"id"`
+	Shape  Shape   `json:"shape"`
+	Pet    Animal  `json:"pet"`
+	Shapes []Shape `json:"shapes"`
+	Zoo    string  `json:"zoo"`
+}
+
+// StructWithSharedOneof defines struct with fields of the same sealed interface
+type StructWithSharedOneof struct {
+	From Shape `json:"from"`
+	To   Shape `json:"to"`
+}
+
+// Labeler defines interface without marker method, which isn't sealed
+type Labeler interface {
+	Label() string
+}
+
+// Badge defines badge implementing Labeler
+type Badge struct {
+	Text string `json:"text"`
+}
+
+// Label returns badge text
+func (Badge) Label() string { return "badge" }
+
+// Solid defines sealed interface embedding Labeler
+type Solid interface {
+	Labeler
+	isSolid()
+}
+
+// Cube defines cube solid
+type Cube struct {
+	Edge float64 `json:"edge"`
+}
+
+func (Cube) isSolid() {}
+
+// Label returns cube label
+func (Cube) Label() string { return "cube" }
+
+// Box defines box without label, which doesn't implement Solid
+type Box struct {
+	Depth float64 `json:"depth"`
+}
+
+func (Box) isSolid() {}
+
+// StructWithInterfaces defines struct with ordinary and embedding interfaces
+type StructWithInterfaces struct {
+	Labeler Labeler `json:"labeler"`
+	Solid   Solid   `json:"solid"`
+}
+
+// StructWithDynamicFields defines struct with dynamic fields
+type StructWithDynamicFields struct {
+	Metadata map[string]interface{} `json:"metadata"`
+	Payload  interface{}            `json:"payload"`
+	Items    []interface{}          `json:"items"`
+	Raw      json.RawMessage        `json:"raw"`
+	Labels   map[string]string      `json:"labels"`
+}
+
+// Point defines point
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// StructWithMapKeys defines struct with non-string map keys
+type StructWithMapKeys struct {
+	ByID      map[int64]*Circle                 `json:"by_id"`
+	Counts    map[uint32]string                 `json:"counts"`
+	Flags     map[bool]int                      `json:"flags"`
+	ByStatus  map[Status]string                 `json:"by_status"`
+	Weights   map[float64]string                `json:"weights"`
+	Labels    map[Point]string                  `json:"labels"`
+	Anonymous map[float32]struct{ Note string } `json:"anonymous"`
+}
+
+// Matrix defines matrix
+type Matrix [][]float64
+
+// StructWithNestedCollections defines struct with nested collections
+type StructWithNestedCollections struct {
+	Matrix  Matrix                   `json:"matrix"`
+	Cube    [][][]int32              `json:"cube"`
+	Groups  map[string][]string      `json:"groups"`
+	Indexes []map[uint32]Point       `json:"indexes"`
+	Blobs   [][]byte                 `json:"blobs"`
+	Docs    []map[string]interface{} `json:"docs"`
+	Buckets map[float64][]string     `json:"buckets"`
+}
+
+// Person defines person
+type Person struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+// Company defines company
+type Company struct {
+	Name string `json:"name"`
+}
+
+// StructWithShadowedField defines struct with field shadowing embedded field
+type StructWithShadowedField struct {
+	Person
+	Age string `json:"age"`
+}
+
+// StructWithEmbeddedConflict defines struct with conflicting embedded fields
+type StructWithEmbeddedConflict struct {
+	Person
+	*Company
+}
+
+// StructWithStableNumbers defines struct whose field numbers are kept by a previous proto file
+type StructWithStableNumbers struct {
+	Age   int      `json:"age"`
+	Email string   `json:"email"`
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+}
+
+// StructWithFieldTags defines struct with tproto tags
+type StructWithFieldTags struct {
+	ID       int64  `json:"id" tproto:"3,name=user_id,type=sint64"`
+	Name     string `json:"name" tproto:"1"`
+	Email    string `json:"email"`
+	Score    uint32 `json:"score" tproto:"type=fixed32"`
+	Password string `json:"password" tproto:"skip"`
+	Secret   string `json:"secret" tproto:"-"`
+}
+
+// StructWithDuplicateFieldNumbers defines struct with duplicate field numbers
+type StructWithDuplicateFieldNumbers struct {
+	First  string `json:"first" tproto:"1"`
+	Second string `json:"second" tproto:"1"`
+}
+
+// StructWithReservedFieldNumber defines struct with field number reserved by protobuf
+type StructWithReservedFieldNumber struct {
+	Field string `json:"field" tproto:"19000"`
+}
+
+// StructWithReservedFieldTag defines struct with field number reserved by previous proto file
+type StructWithReservedFieldTag struct {
+	Phone string `json:"phone" tproto:"2"`
+}
+
+// StructWithForeignTypes defines struct with types of other packages sharing the same name
+type StructWithForeignTypes struct {
+	AuthAccount    *auth.Account   `json:"auth_account"`
+	BillingAccount billing.Account `json:"billing_account"`
+}
+
+// Level defines log level
+type Level int
+
+// Log levels
+const (
+	// LevelDebug is for debugging
+	LevelDebug Level = iota + 1
+	LevelInfo        // default level
+	LevelError
+)
+
+// Stage defines enum declaring the name of the generated zero value
+type Stage int
+
+// Stage values
+const (
+	StageUnspecified Stage = iota + 1
+	StageRunning
+)
+
+// StructWithTakenEnumZeroValue defines struct with enum declaring the name of the generated
+// zero value
+type StructWithTakenEnumZeroValue struct {
+	Stage Stage `json:"stage"`
+}
+
+// StructWithForeignEnumValues defines struct with enums of other packages sharing value names
+type StructWithForeignEnumValues struct {
+	AccountState billing.State     `json:"account_state"`
+	SessionState auth.SessionState `json:"session_state"`
+}
+
+// StructWithComments defines struct with comments.
+//
+// Comments of types, fields and constants are kept.
+// @since v2
+type StructWithComments struct {
+	// ID is the unique id
+	// @example 42
+	ID    int64  `json:"id"`
+	Name  string `json:"name"` // display name
+	Email string `json:"email" description:"email address"`
+	// Level of logs,
+	// defaults to LevelInfo
+	Level Level             `json:"level"` // log level
+	Tags  map[string]string `json:"tags"`  // labels
+	// Pet is the pet
+	Pet Animal `json:"pet"`
+}
+
+// Plan defines subscription plan
+//
+// Deprecated: plans are replaced by tiers.
+type Plan int
+
+// Subscription plans
+const (
+	PlanFree Plan = iota
+	// PlanLegacy is the plan before 2018.
+	//
+	// Deprecated: use PlanFree.
+	PlanLegacy
+)
+
+// StructWithDeprecations defines struct with deprecated fields.
+//
+// Deprecated: use StructWithComments instead,
+// it has the same fields.
+type StructWithDeprecations struct {
+	ID int64 `json:"id"`
+	// Deprecated: use ID.
+	OldID string `json:"old_id"`
+	// Plan of the subscription.
+	//
+	// Deprecated: plans are replaced by tiers.
+	Plan Plan `json:"plan"`
+	// Deprecated: labels are not used anymore.
+	Labels map[string]string `json:"labels"`
+}
+
+// StructWithValidation defines struct with validate tags
+type StructWithValidation struct {
+	Name     string            `json:"name" validate:"required,min=1,max=64"`
+	Email    string            `json:"email" validate:"omitempty,email"`
+	ID       string            `json:"id" validate:"uuid"`
+	Homepage string            `json:"homepage" validate:"url"`
+	Code     string            `json:"code" validate:"alphanum,len=6"`
+	Role     string            `json:"role" validate:"oneof=admin user guest"`
+	Age      uint8             `json:"age" validate:"gte=18,lte=130"`
+	Score    float64           `json:"score" validate:"gt=0,lt=100"`
+	Level    int               `json:"level" validate:"oneof=1 2 3"`
+	Nickname *string           `json:"nickname" validate:"omitempty,min=2"`
+	Tags     []string          `json:"tags" validate:"max=10,unique,dive,min=1"`
+	Labels   map[string]string `json:"labels" validate:"min=1"`
+	Status   Status            `json:"status" validate:"required,oneof=1 2"`
+	Pet      Animal            `json:"pet" validate:"required"`
+	Company  *Company          `json:"company" validate:"required"`
+}
+
+// StructWithEnumValidation defines struct with validate tags of enums and ne rules
+type StructWithEnumValidation struct {
+	Color  Color  `json:"color" validate:"oneof=red blue"`
+	Level  Level  `json:"level" validate:"ne=2"`
+	Mode   string `json:"mode" validate:"ne=read only"`
+	Status Status `json:"status" validate:"oneof=1 4"`
+}
+
+// User defines user
+type User struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// GetUserRequest defines request of UserService.GetUser
+type GetUserRequest struct {
+	ID int64 `json:"id"`
+}
+
+// ListUsersRequest defines request of UserService.ListUsers
+type ListUsersRequest struct {
+	PageSize  int32  `json:"page_size"`
+	PageToken string `json:"page_token"`
+}
+
+// ListUsersResponse defines response of UserService.ListUsers
+type ListUsersResponse struct {
+	Users         []*User `json:"users"`
+	NextPageToken string  `json:"next_page_token"`
+}
+
+// UserService manages users
+//
+// @service
+type UserService interface {
+	// GetUser gets user by id
+	GetUser(ctx context.Context, req *GetUserRequest) (*User, error)
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) // lists users by page
+	// DeleteUser deletes user by id.
+	//
+	// Deprecated: users are deactivated instead.
+	DeleteUser(ctx context.Context, req *GetUserRequest) (*User, error)
+}
+
+// ChatMessage defines message of ChatService
+type ChatMessage struct {
+	From string `json:"from"`
+	Text string `json:"text"`
+}
+
+// ChatStream defines stream of ChatService.Chat
+type ChatStream interface {
+	Send(*ChatMessage) error
+	Recv() (*ChatMessage, error)
+}
+
+// UserStream defines stream of ChatService.SubscribeUsers
+type UserStream interface {
+	Send(*User) error
+}
+
+// ChatService defines service with streaming methods
+//
+// @service
+type ChatService interface {
+	// WatchUsers streams users by the returned channel
+	WatchUsers(ctx context.Context, req *ListUsersRequest) (<-chan *User, error)
+	// ExportUsers streams users by the channel param
+	ExportUsers(ctx context.Context, req *ListUsersRequest, users chan<- *User) error
+	// SubscribeUsers streams users by stream interface
+	SubscribeUsers(ctx context.Context, req *ListUsersRequest, stream UserStream) error
+	// ImportUsers receives users from the channel
+	ImportUsers(ctx context.Context, users <-chan *User) (*ListUsersResponse, error)
+	// Chat streams messages of both sides by stream interface
+	Chat(ctx context.Context, stream ChatStream) error
+	// Relay streams messages of both sides by channels
+	Relay(ctx context.Context, in <-chan *ChatMessage) (<-chan *ChatMessage, error)
+}
+
+// ServiceWithInvalidMethod defines service with unsupported method signature
+type ServiceWithInvalidMethod interface {
+	GetUser(ctx context.Context, id int64) (*User, error)
+}
+
+// ServiceWithInvalidStream defines service streaming responses twice
+type ServiceWithInvalidStream interface {
+	ExportUsers(ctx context.Context, stream UserStream, users chan<- *User) error
+}
+
+// UpdateUserRequest defines request of UserGatewayService.UpdateUser
+type UpdateUserRequest struct {
+	User *User `json:"user"`
+}
+
+// UserGatewayService defines service exposed by grpc-gateway
+//
+// @service
+type UserGatewayService interface {
+	// GetUser gets user by id
+	//
+	// @http GET /v1/users/{ID}
+	GetUser(ctx context.Context, req *GetUserRequest) (*User, error)
+	// @http GET /v1/users
+	ListUsers(ctx context.Context, req *ListUsersRequest) (*ListUsersResponse, error)
+	// @http POST /v1/users *
+	CreateUser(ctx context.Context, user *User) (*User, error)
+	// @http PATCH /v1/users/{User.ID} User
+	UpdateUser(ctx context.Context, req *UpdateUserRequest) (*User, error)
+	// @http DELETE /v1/users/{ID}
+	DeleteUser(ctx context.Context, req *GetUserRequest) (*User, error)
+}
+
+// ServiceWithInvalidHTTPRule defines service with unknown path variable
+type ServiceWithInvalidHTTPRule interface {
+	// @http GET /v1/users/{Name}
+	GetUser(ctx context.Context, req *GetUserRequest) (*User, error)
+}
+
+// ServiceWithRepeatedPathVariable defines service with path variable of repeated field
+type ServiceWithRepeatedPathVariable interface {
+	// @http GET /v1/users/{Users.ID}
+	GetUser(ctx context.Context, req *ListUsersResponse) (*User, error)
+}
+
+// StructWithRequiredFields defines struct with required fields
+type StructWithRequiredFields struct {
+	ID       int64             `json:"id" required:"true"`
+	Name     string            `json:"name"`
+	Nickname *string           `json:"nickname"`
+	Owner    *User             `json:"owner" required:"true"` // owner of the struct
+	Tags     []string          `json:"tags" required:"true"`
+	Labels   map[string]string `json:"labels"`
+}
+
+// StructWithForeignRequiredFields defines struct with required fields of another package
+type StructWithForeignRequiredFields struct {
+	Session *auth.Session `json:"session" required:"true"`
+}
@@ -0,0 +1,297 @@
+package tproto
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/proto"
+)
+
+// ChangeKind defines what a breaking change breaks
+type ChangeKind string
+
+const (
+	// ChangeWire breaks the binary wire format
+	ChangeWire ChangeKind = "wire"
+	// ChangeJSON breaks the canonical JSON mapping
+	ChangeJSON ChangeKind = "json"
+)
+
+// Rules of breaking changes
+const (
+	RuleMessageRenamed         = "MESSAGE_RENAMED"
+	RuleFieldRenumbered        = "FIELD_RENUMBERED"
+	RuleFieldTypeChanged       = "FIELD_TYPE_CHANGED"
+	RuleFieldRemoved           = "FIELD_REMOVED"
+	RuleFieldRenamed           = "FIELD_RENAMED"
+	RuleFieldJSONNameChanged   = "FIELD_JSON_NAME_CHANGED"
+	RuleMessageRenameAmbiguous = "MESSAGE_RENAME_AMBIGUOUS"
+	RuleEnumValueRenumbered    = "ENUM_VALUE_RENUMBERED"
+	RuleEnumValueRemoved       = "ENUM_VALUE_REMOVED"
+	RuleEnumValueRenamed       = "ENUM_VALUE_RENAMED"
+)
+
+// BreakingChange defines a breaking change between an existing message or enum and the generated one,
+// Field is the name of enum value for enums
+type BreakingChange struct {
+	Kind    ChangeKind `json:"kind"`
+	Rule    string     `json:"rule"`
+	Message string     `json:"message,omitempty"`
+	Enum    string     `json:"enum,omitempty"`
+	Field   string     `json:"field,omitempty"`
+	Old     string     `json:"old"`
+	New     string     `json:"new,omitempty"`
+}
+
+// CheckReport defines the report of Check
+type CheckReport struct {
+	Breaking bool              `json:"breaking"`
+	Changes  []*BreakingChange `json:"changes"`
+}
+
+// Check compares parsed messages and enums with the ones of the existing proto, and reports wire
+// and JSON breaking changes. Messages only found in the existing proto are ignored unless they
+// are renamed, since only part of the messages may be parsed
+func (t *Parser) Check(existing *proto.Proto) (report *CheckReport) {
+	report = &CheckReport{Changes: make([]*BreakingChange, 0)}
+	olds := make(map[string]*proto.Message)
+	oldEnums := make(map[string]*proto.Enum)
+	for _, each := range existing.Elements {
+		switch v := each.(type) {
+		case *proto.Message:
+			olds[v.Name] = v
+		case *proto.Enum:
+			oldEnums[v.Name] = v
+		}
+	}
+
+	// a removed message with the same fields as an added one is renamed, unless other removed
+	// or added messages have the same fields too
+	added := make(map[string][]string)
+	for _, name := range sortedMessageNames(t.messages) {
+		if _, ok := olds[name]; !ok {
+			sig := messageSignature(t.messages[name])
+			added[sig] = append(added[sig], name)
+		}
+	}
+	removed := make(map[string][]string)
+	for _, name := range sortedMessageNames(olds) {
+		if _, ok := t.messages[name]; !ok {
+			sig := messageSignature(olds[name])
+			removed[sig] = append(removed[sig], name)
+		}
+	}
+	renames := make(map[string]string)
+	for _, name := range sortedMessageNames(olds) {
+		if _, ok := t.messages[name]; ok {
+			continue
+		}
+		sig := messageSignature(olds[name])
+		candidates := added[sig]
+		switch {
+		case len(candidates) == 0:
+		case len(candidates) == 1 && len(removed[sig]) == 1:
+			renames[name] = candidates[0]
+			report.add(&BreakingChange{Kind: ChangeWire, Rule: RuleMessageRenamed,
+				Message: name, Old: name, New: candidates[0]})
+		default:
+			report.add(&BreakingChange{Kind: ChangeWire, Rule: RuleMessageRenameAmbiguous,
+				Message: name, Old: name, New: strings.Join(candidates, ",")})
+		}
+	}
+
+	for _, name := range sortedMessageNames(olds) {
+		newName := name
+		if renamed, ok := renames[name]; ok {
+			newName = renamed
+		}
+		if msg, ok := t.messages[newName]; ok {
+			report.checkMessage(olds[name], msg, renames)
+		}
+	}
+	for _, name := range sortedEnumNames(oldEnums) {
+		if enum, ok := t.enums[name]; ok {
+			report.checkEnum(oldEnums[name], enum)
+		}
+	}
+	return
+}
+
+func (r *CheckReport) add(change *BreakingChange) {
+	r.Breaking = true
+	r.Changes = append(r.Changes, change)
+}
+
+// checkMessage reports breaking changes of fields between old and new message
+func (r *CheckReport) checkMessage(old, msg *proto.Message, renames map[string]string) {
+	byName := make(map[string]*proto.Field)
+	byNumber := make(map[int]*proto.Field)
+	shapes := make(map[*proto.Field]string)
+	for _, each := range msg.Elements {
+		for _, f := range visiteeFields(each) {
+			byName[f.Name] = f
+			byNumber[f.Sequence] = f
+			shapes[f] = fieldShape(each, f, nil)
+		}
+	}
+	oldNames := make(map[string]bool)
+	for _, f := range messageFields(old) {
+		oldNames[f.Name] = true
+	}
+	ranges, _ := messageReserved(msg)
+
+	for _, each := range old.Elements {
+		for _, of := range visiteeFields(each) {
+			oldShape := fieldShape(each, of, renames)
+			f, ok := byName[of.Name]
+			if !ok {
+				// a field with the same number and a new name is renamed
+				if f, ok = byNumber[of.Sequence]; ok && oldNames[f.Name] {
+					f, ok = nil, false
+				}
+			}
+			if !ok {
+				if !isReservedNumber(ranges, of.Sequence) {
+					r.add(&BreakingChange{Kind: ChangeWire, Rule: RuleFieldRemoved,
+						Message: old.Name, Field: of.Name, Old: fmt.Sprint(of.Sequence)})
+				}
+				continue
+			}
+
+			if f.Sequence != of.Sequence {
+				r.add(&BreakingChange{Kind: ChangeWire, Rule: RuleFieldRenumbered,
+					Message: old.Name, Field: of.Name,
+					Old: fmt.Sprint(of.Sequence), New: fmt.Sprint(f.Sequence)})
+			}
+			if shapes[f] != oldShape {
+				r.add(&BreakingChange{Kind: ChangeWire, Rule: RuleFieldTypeChanged,
+					Message: old.Name, Field: of.Name, Old: oldShape, New: shapes[f]})
+			}
+			if oldJSON, newJSON := fieldJSONName(of), fieldJSONName(f); oldJSON != newJSON {
+				rule := RuleFieldJSONNameChanged
+				if f.Name != of.Name {
+					rule = RuleFieldRenamed
+				}
+				r.add(&BreakingChange{Kind: ChangeJSON, Rule: rule,
+					Message: old.Name, Field: of.Name, Old: oldJSON, New: newJSON})
+			}
+		}
+	}
+	return
+}
+
+// checkEnum reports breaking changes of values between old and new enum, values are
+// encoded by numbers on the wire and by names in JSON
+func (r *CheckReport) checkEnum(old, enum *proto.Enum) {
+	byName := make(map[string]int)
+	byNumber := make(map[int]string)
+	for _, each := range enum.Elements {
+		if v, ok := each.(*proto.EnumField); ok {
+			byName[v.Name] = v.Integer
+			if _, ok := byNumber[v.Integer]; !ok {
+				byNumber[v.Integer] = v.Name
+			}
+		}
+	}
+
+	for _, each := range old.Elements {
+		v, ok := each.(*proto.EnumField)
+		if !ok {
+			continue
+		}
+		if n, ok := byName[v.Name]; ok {
+			if n != v.Integer {
+				r.add(&BreakingChange{Kind: ChangeWire, Rule: RuleEnumValueRenumbered,
+					Enum: old.Name, Field: v.Name, Old: fmt.Sprint(v.Integer), New: fmt.Sprint(n)})
+			}
+			continue
+		}
+		if name, ok := byNumber[v.Integer]; ok {
+			r.add(&BreakingChange{Kind: ChangeJSON, Rule: RuleEnumValueRenamed,
+				Enum: old.Name, Field: v.Name, Old: v.Name, New: name})
+			continue
+		}
+		r.add(&BreakingChange{Kind: ChangeWire, Rule: RuleEnumValueRemoved,
+			Enum: old.Name, Field: v.Name, Old: fmt.Sprint(v.Integer)})
+	}
+}
+
+// fieldShape returns the type of field, including its cardinality,
+// message types are resolved by renames
+func fieldShape(v proto.Visitee, f *proto.Field, renames map[string]string) string {
+	typ := f.Type
+	if renamed, ok := renames[typ]; ok {
+		typ = renamed
+	}
+	switch field := v.(type) {
+	case *proto.MapField:
+		return fmt.Sprintf("map<%s, %s>", field.KeyType, typ)
+	case *proto.NormalField:
+		if field.Repeated {
+			return "repeated " + typ
+		}
+	}
+	return typ
+}
+
+// messageSignature returns fields of message as a string, ignoring field names
+func messageSignature(msg *proto.Message) string {
+	var fields []string
+	for _, each := range msg.Elements {
+		for _, f := range visiteeFields(each) {
+			fields = append(fields, fmt.Sprintf("%d:%s", f.Sequence, fieldShape(each, f, nil)))
+		}
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ";")
+}
+
+// fieldJSONName returns the JSON name of proto field, which is the json_name option or
+// the field name without underscores, as protoc does
+func fieldJSONName(f *proto.Field) string {
+	for _, opt := range f.Options {
+		if opt.Name == "json_name" {
+			return strings.Trim(opt.Constant.Source, `"`)
+		}
+	}
+	var buf strings.Builder
+	upper := false
+	for _, r := range f.Name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+func isReservedNumber(ranges []proto.Range, n int) bool {
+	for _, r := range ranges {
+		if n >= r.From && (r.Max || n <= r.To) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedMessageNames(messages map[string]*proto.Message) (names []string) {
+	for name := range messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func sortedEnumNames(enums map[string]*proto.Enum) (names []string) {
+	for name := range enums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,177 @@
+package tproto
+
+import (
+	"go/ast"
+	"strings"
+
+	"github.com/emicklei/proto"
+)
+
+// isDirective checks whether doc line is a directive of tproto or the decorator of parsed types,
+// e.g. "@oneof Circle Square", other lines beginning with @ like "@deprecated use X" are docs
+func (t *Parser) isDirective(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	switch fields[0] {
+	case OneofDecorator, HTTPDecorator:
+		return true
+	}
+	return t.opts.Decorator != "" && fields[0] == t.opts.Decorator
+}
+
+// protoComment converts golang comment groups into a proto comment, groups are separated by
+// empty lines. It returns nil if there is no text
+func (t *Parser) protoComment(groups ...*ast.CommentGroup) (comment *proto.Comment) {
+	var lines []string
+	for _, group := range groups {
+		text := strings.TrimSpace(group.Text())
+		if text == "" {
+			continue
+		}
+		if len(lines) != 0 {
+			lines = append(lines, "")
+		}
+		for _, line := range strings.Split(text, "\n") {
+			if t.isDirective(line) {
+				continue
+			}
+			if line != "" {
+				line = " " + line
+			}
+			lines = append(lines, line)
+		}
+	}
+	for len(lines) != 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return
+	}
+	comment = &proto.Comment{Lines: lines}
+	return
+}
+
+// protoFieldComment converts golang comment groups into a proto comment of field or enum
+// value, which is printed within aligned columns, so paragraphs are not separated
+func (t *Parser) protoFieldComment(groups ...*ast.CommentGroup) (comment *proto.Comment) {
+	comment = t.protoComment(groups...)
+	if comment == nil {
+		return
+	}
+	lines := comment.Lines[:0]
+	for _, line := range comment.Lines {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	comment.Lines = lines
+	return
+}
+
+// protoInlineComment converts golang line comment into a single line proto comment
+func protoInlineComment(group *ast.CommentGroup) (comment *proto.Comment) {
+	text := strings.Join(strings.Fields(group.Text()), " ")
+	if text == "" {
+		return
+	}
+	comment = &proto.Comment{Lines: []string{" " + text}}
+	return
+}
+
+// typeDoc returns the doc comment of type, which is the doc of its declaration if the
+// declaration has only one spec
+func typeDoc(pkg *ast.Package, ts *ast.TypeSpec) *ast.CommentGroup {
+	if ts == nil {
+		return nil
+	}
+	if ts.Doc != nil {
+		return ts.Doc
+	}
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if ok && len(genDecl.Specs) == 1 && genDecl.Specs[0] == ts {
+				return genDecl.Doc
+			}
+		}
+	}
+	return nil
+}
+
+// isDeprecated checks whether golang doc comment has a paragraph beginning with
+// "Deprecated:", the reason is kept by the proto comment
+func isDeprecated(doc *ast.CommentGroup) bool {
+	for _, paragraph := range strings.Split(doc.Text(), "\n\n") {
+		if strings.HasPrefix(paragraph, "Deprecated:") {
+			return true
+		}
+	}
+	return false
+}
+
+// deprecatedOption returns the deprecated option, which is embedded for fields
+func deprecatedOption(embedded bool) *proto.Option {
+	return &proto.Option{
+		Name:       "deprecated",
+		Constant:   proto.Literal{Source: "true"},
+		IsEmbedded: embedded,
+	}
+}
+
+// setFieldComments sets comments of proto field by doc and line comments of golang field,
+// the description tag is used if there is no doc comment. Doc comments of map fields and
+// oneofs are moved into the inline comment and the doc comment of the first oneof field.
+// Deprecated fields get the deprecated option
+func (t *Parser) setFieldComments(fieldProto proto.Visitee, field *goField) {
+	if field.field == nil {
+		return
+	}
+	if isDeprecated(field.field.Doc) {
+		for _, f := range visiteeFields(fieldProto) {
+			f.Options = append(f.Options, deprecatedOption(true))
+		}
+	}
+	comment := t.protoFieldComment(field.field.Doc)
+	if comment == nil && field.description != "" {
+		comment = &proto.Comment{Lines: []string{" " + field.description}}
+	}
+	inlineComment := protoInlineComment(field.field.Comment)
+
+	switch v := fieldProto.(type) {
+	case *proto.NormalField:
+		v.Comment, v.InlineComment = comment, inlineComment
+	case *proto.MapField:
+		if comment != nil {
+			inlineComment = mergeInlineComments(comment, inlineComment)
+		}
+		v.InlineComment = inlineComment
+	case *proto.Oneof:
+		if comment == nil {
+			comment = inlineComment
+		}
+		if len(v.Elements) == 0 || comment == nil {
+			return
+		}
+		if f, ok := v.Elements[0].(*proto.OneOfField); ok {
+			f.Comment = comment
+		}
+	}
+}
+
+// mergeInlineComments joins lines of comments into a single line comment
+func mergeInlineComments(comments ...*proto.Comment) *proto.Comment {
+	var texts []string
+	for _, c := range comments {
+		if c == nil {
+			continue
+		}
+		for _, line := range c.Lines {
+			if line = strings.TrimSpace(line); line != "" {
+				texts = append(texts, line)
+			}
+		}
+	}
+	return &proto.Comment{Lines: []string{" " + strings.Join(texts, " ")}}
+}
@@ -0,0 +1,366 @@
+package tproto
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// enumZeroValueSuffix defines the suffix of the zero value added to enums without one
+const enumZeroValueSuffix = "Unspecified"
+
+// goConst defines a golang typed constant
+type goConst struct {
+	name    string
+	value   constant.Value
+	doc     *ast.CommentGroup
+	comment *ast.CommentGroup
+}
+
+// typedConsts collects all typed constants of package, keyed by type name
+func (t *Parser) typedConsts(pkg *ast.Package) (consts map[string][]*goConst) {
+	if consts, ok := t.consts[pkg]; ok {
+		return consts
+	}
+
+	consts = make(map[string][]*goConst)
+	known := make(map[string]constant.Value)
+	knownTypes := make(map[string]string)
+	fileNames := make(sort.StringSlice, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		fileNames = append(fileNames, name)
+	}
+	fileNames.Sort()
+	for _, name := range fileNames {
+		for _, decl := range pkg.Files[name].Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.CONST {
+				continue
+			}
+			var typeName string
+			var values []ast.Expr
+			for i, s := range genDecl.Specs {
+				vspec := s.(*ast.ValueSpec)
+				if vspec.Type != nil || len(vspec.Values) != 0 {
+					typeName, values = "", vspec.Values
+					if ident, ok := vspec.Type.(*ast.Ident); ok {
+						typeName = ident.Name
+					}
+				}
+				for j, ident := range vspec.Names {
+					if j >= len(values) {
+						break
+					}
+					value, err := evalConstExpr(values[j], i, known)
+					if err != nil {
+						log.Debugf("ignored constant %s: %s", ident.Name, err)
+						continue
+					}
+					cTypeName := typeName
+					if cTypeName == "" {
+						cTypeName = constExprType(values[j], knownTypes)
+					}
+					known[ident.Name] = value
+					knownTypes[ident.Name] = cTypeName
+					if ident.Name == "_" || cTypeName == "" {
+						continue
+					}
+					doc := vspec.Doc
+					if doc == nil && len(genDecl.Specs) == 1 {
+						doc = genDecl.Doc
+					}
+					consts[cTypeName] = append(consts[cTypeName], &goConst{
+						name:    ident.Name,
+						value:   value,
+						doc:     doc,
+						comment: vspec.Comment,
+					})
+				}
+			}
+		}
+	}
+	t.consts[pkg] = consts
+	return
+}
+
+// isEnumType checks whether the named type should be parsed into proto enum
+func (t *Parser) isEnumType(pkg *ast.Package, ts *ast.TypeSpec) bool {
+	ident, ok := ts.Type.(*ast.Ident)
+	if !ok || !isEnumBasicType(ident.Name) {
+		return false
+	}
+	return len(t.typedConsts(pkg)[ts.Name.Name]) != 0
+}
+
+func (t *Parser) parseEnum(pkg *ast.Package, ts *ast.TypeSpec, title string) (err error) {
+	if t.parsed[t.identity(pkg, title)] {
+		return
+	}
+	t.parsed[t.identity(pkg, title)] = true
+	enumName := t.protoTypeName(pkg, title)
+	err = t.registerType(pkg, title, enumName)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	// enum values are scoped by the namespace of enum
+	_, valuePrefix := namespaceOf(title)
+
+	consts := t.typedConsts(pkg)[ts.Name.Name]
+	isString := ts.Type.(*ast.Ident).Name == "string"
+	fields := make([]*proto.EnumField, 0, len(consts)+1)
+	hasZero, hasAlias := false, false
+	seen := make(map[int]bool)
+	next := 1
+	for _, c := range consts {
+		f := &proto.EnumField{Name: t.protoEnumValueName(valuePrefix, c.name)}
+		f.Comment = t.protoFieldComment(c.doc)
+		f.InlineComment = protoInlineComment(c.comment)
+		if isDeprecated(c.doc) {
+			f.ValueOption = deprecatedOption(true)
+		}
+		if isString {
+			s := constant.StringVal(c.value)
+			if s != "" {
+				f.Integer = next
+				next++
+			}
+			// the string value is kept inline, the line comment is moved to the doc comment
+			f.Comment = t.protoFieldComment(c.doc, c.comment)
+			f.InlineComment = &proto.Comment{Lines: []string{" " + strconv.Quote(s)}}
+		} else {
+			n, exact := constant.Int64Val(c.value)
+			if !exact || n < math.MinInt32 || n > math.MaxInt32 {
+				err = errors.Errorf("enum value %s of %s out of int32 range", c.name, title)
+				return
+			}
+			f.Integer = int(n)
+		}
+		hasZero = hasZero || f.Integer == 0
+		hasAlias = hasAlias || seen[f.Integer]
+		seen[f.Integer] = true
+		fields = append(fields, f)
+	}
+	if isString {
+		previous := t.enums[enumName]
+		if previous == nil {
+			previous = t.lockedEnum(enumName)
+		}
+		stabilizeEnumValues(fields, previous)
+	}
+	values := make(map[string]int, len(consts))
+	for i, c := range consts {
+		if isString {
+			values[constant.StringVal(c.value)] = fields[i].Integer
+		} else {
+			values[c.value.ExactString()] = fields[i].Integer
+		}
+	}
+	t.enumValues[enumName] = values
+	if !hasZero {
+		zero := &proto.EnumField{
+			Name: t.protoEnumValueName(valuePrefix, valuePrefix+enumZeroValueSuffix),
+		}
+		for _, f := range fields {
+			if f.Name == zero.Name {
+				err = errors.Errorf("enum value %s of %s conflicts with the generated zero value, "+
+					"rename it or make it zero", f.Name, title)
+				return
+			}
+		}
+		fields = append(fields, zero)
+	}
+	err = t.registerEnumValues(pkg, title, enumName, fields)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		// zero value must be the first one
+		if fields[i].Integer == 0 || fields[j].Integer == 0 {
+			return fields[i].Integer == 0 && fields[j].Integer != 0
+		}
+		return fields[i].Integer < fields[j].Integer
+	})
+
+	enum := new(proto.Enum)
+	enum.Name = enumName
+	doc := typeDoc(pkg, ts)
+	enum.Comment = t.protoComment(doc)
+	if isDeprecated(doc) {
+		enum.Elements = append(enum.Elements, deprecatedOption(false))
+	}
+	if hasAlias {
+		enum.Elements = append(enum.Elements, &proto.Option{
+			Name:     "allow_alias",
+			Constant: proto.Literal{Source: "true"},
+		})
+	}
+	for _, f := range fields {
+		enum.Elements = append(enum.Elements, f)
+	}
+	t.enums[enum.Name] = enum
+	return
+}
+
+// registerEnumValues registers names of enum values, which are scoped by the namespace of
+// enum rather than enum itself, so values of different enums must not share names
+func (t *Parser) registerEnumValues(pkg *ast.Package, title, enumName string,
+	fields []*proto.EnumField) (err error) {
+	identity := t.identity(pkg, title)
+	namespace, _ := namespaceOf(enumName)
+	for _, f := range fields {
+		scoped := namespace + "." + f.Name
+		if other, ok := t.valueNames[scoped]; ok && other != identity {
+			err = errors.Errorf("enum value %s of %s conflicts with %s, rename one of them",
+				f.Name, identity, other)
+			return
+		}
+		t.valueNames[scoped] = identity
+	}
+	return
+}
+
+// stabilizeEnumValues reuses numbers of the previous enum for values of string enums, which
+// are numbered in declaration order, new values get numbers after the previous ones. Numbers
+// of removed values are kept by the lockfile only, enums are not rendered with reserved
+// statements since the proto parser can't load them back
+func stabilizeEnumValues(fields []*proto.EnumField, previous *proto.Enum) {
+	if previous == nil {
+		return
+	}
+
+	numbers := make(map[string]int)
+	maxNumber := 0
+	for _, each := range previous.Elements {
+		if f, ok := each.(*proto.EnumField); ok && f.Integer != 0 {
+			numbers[f.Name] = f.Integer
+			if f.Integer > maxNumber {
+				maxNumber = f.Integer
+			}
+		}
+	}
+	taken := make(map[int]bool)
+	for _, f := range fields {
+		if f.Integer == 0 {
+			continue
+		}
+		if n, ok := numbers[f.Name]; ok && !taken[n] {
+			f.Integer = n
+		} else {
+			maxNumber++
+			f.Integer = maxNumber
+		}
+		taken[f.Integer] = true
+	}
+}
+
+func isEnumBasicType(name string) bool {
+	switch name {
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "string":
+		return true
+	}
+	return false
+}
+
+// constExprType returns the type name of untyped constant expr, the type is implied by
+// type conversions or typed constants
+func constExprType(expr ast.Expr, knownTypes map[string]string) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return knownTypes[e.Name]
+	case *ast.ParenExpr:
+		return constExprType(e.X, knownTypes)
+	case *ast.CallExpr:
+		if fun, ok := e.Fun.(*ast.Ident); ok {
+			return fun.Name
+		}
+	case *ast.UnaryExpr:
+		return constExprType(e.X, knownTypes)
+	case *ast.BinaryExpr:
+		if typeName := constExprType(e.X, knownTypes); typeName != "" {
+			return typeName
+		}
+		return constExprType(e.Y, knownTypes)
+	}
+	return ""
+}
+
+// evalConstExpr evaluates golang constant expr
+func evalConstExpr(expr ast.Expr, iota int, known map[string]constant.Value) (
+	value constant.Value, err error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		value = constant.MakeFromLiteral(e.Value, e.Kind, 0)
+	case *ast.Ident:
+		if e.Name == "iota" {
+			value = constant.MakeInt64(int64(iota))
+			break
+		}
+		v, ok := known[e.Name]
+		if !ok {
+			err = errors.Errorf("unknown constant %s", e.Name)
+			return
+		}
+		value = v
+	case *ast.ParenExpr:
+		return evalConstExpr(e.X, iota, known)
+	case *ast.CallExpr:
+		// type conversion
+		if len(e.Args) != 1 {
+			err = errors.Errorf("unsupported constant expr %T", e)
+			return
+		}
+		return evalConstExpr(e.Args[0], iota, known)
+	case *ast.UnaryExpr:
+		x, e1 := evalConstExpr(e.X, iota, known)
+		if e1 != nil {
+			err = errors.WithStack(e1)
+			return
+		}
+		value = constant.UnaryOp(e.Op, x, 0)
+	case *ast.BinaryExpr:
+		x, e1 := evalConstExpr(e.X, iota, known)
+		if e1 != nil {
+			err = errors.WithStack(e1)
+			return
+		}
+		y, e2 := evalConstExpr(e.Y, iota, known)
+		if e2 != nil {
+			err = errors.WithStack(e2)
+			return
+		}
+		switch e.Op {
+		case token.SHL, token.SHR:
+			s, ok := constant.Uint64Val(y)
+			if !ok {
+				err = errors.Errorf("invalid shift count %s", y)
+				return
+			}
+			value = constant.Shift(x, e.Op, uint(s))
+		case token.QUO:
+			op := e.Op
+			if x.Kind() == constant.Int && y.Kind() == constant.Int {
+				op = token.QUO_ASSIGN
+			}
+			value = constant.BinaryOp(x, op, y)
+		default:
+			value = constant.BinaryOp(x, e.Op, y)
+		}
+	default:
+		err = errors.Errorf("unsupported constant expr %T", e)
+		return
+	}
+	if value.Kind() == constant.Unknown {
+		err = errors.Errorf("invalid constant expr")
+	}
+	return
+}
@@ -0,0 +1,259 @@
+package tproto
+
+import (
+	"bytes"
+	"go/ast"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+)
+
+// protoPackage returns the proto package of golang package, which is derived from its
+// import path, e.g. github.com/wy-z/tproto/samples -> github_com.wy_z.tproto.samples
+func (t *Parser) protoPackage(pkg *ast.Package) string {
+	var parts []string
+	for _, part := range strings.Split(t.pkgPath(pkg), "/") {
+		if part = protoPackagePart(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// protoPackagePart converts element of import path into a proto identifier
+func protoPackagePart(part string) string {
+	runes := []rune(strings.ToLower(part))
+	for i, r := range runes {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			runes[i] = '_'
+		}
+	}
+	if len(runes) != 0 && runes[0] >= '0' && runes[0] <= '9' {
+		runes = append([]rune{'_'}, runes...)
+	}
+	return string(runes)
+}
+
+// protoFilePath returns the path of proto file of golang package, which mirrors its import
+// path, e.g. github.com/wy-z/tproto/samples/samples.proto
+func (t *Parser) protoFilePath(pkg *ast.Package) string {
+	return path.Join(t.pkgPath(pkg), pkg.Name+".proto")
+}
+
+// owner returns the golang package declaring message or enum, messages and enums which are
+// not parsed, e.g. loaded from proto file, belong to the parsed package
+func (t *Parser) owner(name string) *ast.Package {
+	if pkg, ok := t.owners[name]; ok {
+		return pkg
+	}
+	return t.root
+}
+
+// RenderProtoFiles renders proto messages, enums and services into one file per golang package, which
+// should be used with ParserOptions.PackageFiles. Files are keyed by paths mirroring import
+// paths, types of other packages are imported and referenced by fully qualified names
+func (t *Parser) RenderProtoFiles() (files map[string]*bytes.Buffer, err error) {
+	files = make(map[string]*bytes.Buffer)
+	if t.root == nil {
+		return
+	}
+
+	enums := make(map[*ast.Package][]*proto.Enum)
+	for name, enum := range t.enums {
+		pkg := t.owner(name)
+		enums[pkg] = append(enums[pkg], enum)
+	}
+	messages := make(map[*ast.Package]map[string]*proto.Message)
+	deps := make(map[*ast.Package]map[*ast.Package]bool)
+	for name, msg := range t.messages {
+		pkg := t.owner(name)
+		if messages[pkg] == nil {
+			messages[pkg] = make(map[string]*proto.Message)
+			deps[pkg] = make(map[*ast.Package]bool)
+		}
+		messages[pkg][name] = msg
+		for _, typ := range messageFieldTypes(msg) {
+			if dep, ok := t.owners[typ]; ok && dep != pkg {
+				deps[pkg][dep] = true
+			}
+		}
+	}
+	services := make(map[*ast.Package]map[string]*proto.Service)
+	for name, service := range t.services {
+		pkg := t.owner(name)
+		if services[pkg] == nil {
+			services[pkg] = make(map[string]*proto.Service)
+		}
+		if deps[pkg] == nil {
+			deps[pkg] = make(map[*ast.Package]bool)
+		}
+		services[pkg][name] = service
+		for _, rpc := range serviceRPCs(service) {
+			for _, typ := range []string{rpc.RequestType, rpc.ReturnsType} {
+				if dep, ok := t.owners[typ]; ok && dep != pkg {
+					deps[pkg][dep] = true
+				}
+			}
+		}
+	}
+	pkgs := make(map[string]*ast.Package)
+	for pkg := range enums {
+		pkgs[t.protoFilePath(pkg)] = pkg
+	}
+	for pkg := range messages {
+		pkgs[t.protoFilePath(pkg)] = pkg
+	}
+	for pkg := range services {
+		pkgs[t.protoFilePath(pkg)] = pkg
+	}
+	err = t.checkImportCycles(pkgs, deps)
+	if err != nil {
+		return
+	}
+
+	for filePath, pkg := range pkgs {
+		protoPkg := t.protoPackage(pkg)
+		p := new(proto.Proto)
+		p.Elements = append(p.Elements, t.syntaxElement())
+		p.Elements = append(p.Elements, &proto.Package{
+			Name: protoPkg,
+		})
+
+		imports := protoImports(messages[pkg], services[pkg])
+		for dep := range deps[pkg] {
+			imports = append(imports, t.protoFilePath(dep))
+		}
+		sort.Strings(imports)
+		for _, filename := range imports {
+			p.Elements = append(p.Elements, &proto.Import{
+				Filename: filename,
+			})
+		}
+		p.Elements = append(p.Elements, t.fileOptions(pkg)...)
+
+		sort.Slice(enums[pkg], func(i, j int) bool {
+			return enums[pkg][i].Name < enums[pkg][j].Name
+		})
+		for _, enum := range enums[pkg] {
+			e := *enum
+			e.Name = localTypeName(enum.Name, protoPkg)
+			p.Elements = append(p.Elements, &e)
+		}
+		for _, name := range sortedMessageNames(messages[pkg]) {
+			p.Elements = append(p.Elements, localMessage(messages[pkg][name], protoPkg))
+		}
+		for _, each := range sortedServices(services[pkg]) {
+			p.Elements = append(p.Elements, localService(each.(*proto.Service), protoPkg))
+		}
+
+		files[filePath] = formatProto(p)
+	}
+	return
+}
+
+// checkImportCycles reports import cycles between proto files, which protoc rejects
+func (t *Parser) checkImportCycles(pkgs map[string]*ast.Package,
+	deps map[*ast.Package]map[*ast.Package]bool) (err error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	states := make(map[*ast.Package]int)
+	var stack []*ast.Package
+	var visit func(pkg *ast.Package) error
+	visit = func(pkg *ast.Package) error {
+		switch states[pkg] {
+		case visiting:
+			var cycle []string
+			for i := len(stack) - 1; i >= 0; i-- {
+				cycle = append([]string{t.pkgPath(stack[i])}, cycle...)
+				if stack[i] == pkg {
+					break
+				}
+			}
+			cycle = append(cycle, t.pkgPath(pkg))
+			return errors.Errorf("import cycle between proto files: %s", strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		states[pkg] = visiting
+		stack = append(stack, pkg)
+		depPaths := make([]string, 0, len(deps[pkg]))
+		for dep := range deps[pkg] {
+			depPaths = append(depPaths, t.protoFilePath(dep))
+		}
+		sort.Strings(depPaths)
+		for _, depPath := range depPaths {
+			if e := visit(pkgs[depPath]); e != nil {
+				return e
+			}
+		}
+		stack = stack[:len(stack)-1]
+		states[pkg] = visited
+		return nil
+	}
+
+	filePaths := make([]string, 0, len(pkgs))
+	for filePath := range pkgs {
+		filePaths = append(filePaths, filePath)
+	}
+	sort.Strings(filePaths)
+	for _, filePath := range filePaths {
+		err = visit(pkgs[filePath])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// localTypeName strips proto package from type name declared in the package, types of
+// sub-packages are kept fully qualified
+func localTypeName(name, protoPkg string) string {
+	local := strings.TrimPrefix(name, protoPkg+".")
+	if local == name || strings.Contains(local, ".") {
+		return name
+	}
+	return local
+}
+
+// localMessage returns a copy of message whose name and field types of the same proto
+// package are not qualified
+func localMessage(msg *proto.Message, protoPkg string) *proto.Message {
+	localField := func(f *proto.Field) *proto.Field {
+		c := *f
+		c.Type = localTypeName(f.Type, protoPkg)
+		return &c
+	}
+
+	m := *msg
+	m.Name = localTypeName(msg.Name, protoPkg)
+	m.Elements = make([]proto.Visitee, 0, len(msg.Elements))
+	for _, each := range msg.Elements {
+		switch v := each.(type) {
+		case *proto.NormalField:
+			f := *v
+			f.Field = localField(v.Field)
+			each = &f
+		case *proto.MapField:
+			f := *v
+			f.Field = localField(v.Field)
+			each = &f
+		case *proto.Oneof:
+			o := *v
+			o.Elements = make([]proto.Visitee, 0, len(v.Elements))
+			for _, e := range v.Elements {
+				if of, ok := e.(*proto.OneOfField); ok {
+					e = &proto.OneOfField{Field: localField(of.Field)}
+				}
+				o.Elements = append(o.Elements, e)
+			}
+			each = &o
+		}
+		m.Elements = append(m.Elements, each)
+	}
+	return &m
+}
@@ -0,0 +1,280 @@
+package tproto
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emicklei/proto"
+)
+
+// indentSeparator defines the indentation of formatted proto files
+const indentSeparator = "  "
+
+// formatProto formats proto file. Statements of a block are aligned in columns as protofmt
+// does, except that options and inline comments of fields are not aligned, lines don't end
+// with padding and nested definitions are separated from preceding statements by an empty line
+func formatProto(p *proto.Proto) (buf *bytes.Buffer) {
+	buf = bytes.NewBuffer(nil)
+	hasOption, hasDefinition := false, false
+	for _, each := range p.Elements {
+		switch v := each.(type) {
+		case *proto.Syntax:
+			fmt.Fprintf(buf, "syntax = %q;\n", v.Value)
+		case *editionStatement:
+			fmt.Fprintf(buf, "edition = %q;\n", v.Edition)
+		case *proto.Package:
+			fmt.Fprintf(buf, "\npackage %s;\n", v.Name)
+		case *proto.Import:
+			fmt.Fprintf(buf, "import %q;\n", v.Filename)
+		case *proto.Option:
+			if !hasOption {
+				buf.WriteString("\n")
+				hasOption = true
+			}
+			fmt.Fprintf(buf, "option %s = %s;\n", v.Name, v.Constant.SourceRepresentation())
+		default:
+			// the first definition and documented ones begin with an empty line
+			if !hasDefinition || isDocumented(each) {
+				buf.WriteString("\n")
+			}
+			hasDefinition = true
+			formatDefinition(buf, "", each)
+		}
+	}
+	return
+}
+
+// isDocumented checks whether element has a doc comment
+func isDocumented(v proto.Visitee) bool {
+	documented, ok := v.(proto.Documented)
+	return ok && documented.Doc() != nil
+}
+
+// formatDefinition formats message, enum, oneof or service with its doc comment
+func formatDefinition(buf *bytes.Buffer, indent string, v proto.Visitee) {
+	if isDocumented(v) {
+		for _, line := range v.(proto.Documented).Doc().Lines {
+			fmt.Fprintf(buf, "%s//%s\n", indent, line)
+		}
+	}
+	switch v := v.(type) {
+	case *proto.Message:
+		formatBlock(buf, indent, "message "+v.Name, v.Elements)
+	case *proto.Enum:
+		formatBlock(buf, indent, "enum "+v.Name, v.Elements)
+	case *proto.Oneof:
+		formatBlock(buf, indent, "oneof "+v.Name, v.Elements)
+	case *proto.Service:
+		formatBlock(buf, indent, "service "+v.Name, v.Elements)
+	}
+}
+
+// formatBlock formats block of definition. Statements are aligned in groups, a group ends at
+// a doc comment or another kind of statement
+func formatBlock(buf *bytes.Buffer, indent, header string, elements []proto.Visitee) {
+	fmt.Fprintf(buf, "%s%s {", indent, header)
+	if len(elements) != 0 {
+		buf.WriteString("\n")
+	}
+	inner := indent + indentSeparator
+	var rows [][]column
+	lastKind := ""
+	for i, each := range elements {
+		kind, row := statementColumns(each)
+		if row == nil {
+			printColumns(buf, inner, rows)
+			rows, lastKind = nil, ""
+			if i != 0 {
+				buf.WriteString("\n")
+			}
+			formatDefinition(buf, inner, each)
+			continue
+		}
+		if kind != lastKind || isDocumented(each) {
+			printColumns(buf, inner, rows)
+			rows, lastKind = nil, kind
+		}
+		if isDocumented(each) {
+			for _, line := range each.(proto.Documented).Doc().Lines {
+				rows = append(rows, []column{{text: "//" + line}})
+			}
+		}
+		rows = append(rows, row)
+	}
+	printColumns(buf, inner, rows)
+	buf.WriteString(indent + "}\n")
+}
+
+// statementColumns returns the kind and columns of statement, nil columns means v is a
+// nested definition
+func statementColumns(v proto.Visitee) (kind string, cols []column) {
+	switch v := v.(type) {
+	case *proto.Option:
+		return "option", optionColumns(v)
+	case *proto.RPC:
+		return "rpc", rpcColumns(v)
+	case *proto.Reserved:
+		return "reserved", []column{{text: reservedStatement(v)}}
+	case *proto.NormalField:
+		label := ""
+		switch {
+		case v.Optional:
+			label = "optional "
+		case v.Required:
+			label = "required "
+		}
+		repeated := ""
+		if v.Repeated {
+			repeated = "repeated "
+		}
+		return "field", []column{{repeated, alignLeft}, {label, alignLeft},
+			{v.Type, alignRight}, {" ", alignLeft}, {v.Name, alignLeft}, {" = ", alignLeft},
+			{strconv.Itoa(v.Sequence), alignRight}, {text: statementEnd(v.Options, v.InlineComment)}}
+	case *proto.MapField:
+		return "map", []column{{text: "map <"}, {v.KeyType, alignRight}, {text: ","},
+			{v.Type, alignLeft}, {text: "> "}, {v.Name, alignRight}, {" = ", alignLeft},
+			{strconv.Itoa(v.Sequence), alignRight}, {text: statementEnd(v.Options, v.InlineComment)}}
+	case *proto.OneOfField:
+		return "oneof field", []column{{v.Type, alignRight}, {" ", alignLeft}, {v.Name, alignLeft},
+			{" = ", alignLeft}, {strconv.Itoa(v.Sequence), alignRight},
+			{text: statementEnd(v.Options, v.InlineComment)}}
+	case *proto.EnumField:
+		var options []*proto.Option
+		if v.ValueOption != nil {
+			options = append(options, v.ValueOption)
+		}
+		return "enum field", []column{{v.Name, alignLeft}, {" = ", alignLeft},
+			{strconv.Itoa(v.Integer), alignRight}, {text: statementEnd(options, v.InlineComment)}}
+	}
+	return
+}
+
+// statementEnd returns options, the semicolon and the inline comment ending a field statement
+func statementEnd(options []*proto.Option, comment *proto.Comment) string {
+	end := ""
+	if len(options) != 0 {
+		pairs := make([]string, 0, len(options))
+		for _, o := range options {
+			pairs = append(pairs, o.Name+" = "+o.Constant.SourceRepresentation())
+		}
+		end = " [" + strings.Join(pairs, ", ") + "]"
+	}
+	end += ";"
+	if comment != nil {
+		end += " //" + comment.Message()
+	}
+	return end
+}
+
+// reservedStatement returns the reserved statement of numbers or names
+func reservedStatement(r *proto.Reserved) string {
+	reserved := make([]string, 0, len(r.Ranges)+len(r.FieldNames))
+	for _, each := range r.Ranges {
+		reserved = append(reserved, each.SourceRepresentation())
+	}
+	for _, each := range r.FieldNames {
+		reserved = append(reserved, strconv.Quote(each))
+	}
+	return "reserved " + strings.Join(reserved, ", ") + ";"
+}
+
+// optionColumns returns columns of option statement
+func optionColumns(o *proto.Option) (cols []column) {
+	cols = append(cols, column{"option ", alignLeft}, column{o.Name, alignRight},
+		column{" = ", alignLeft}, column{o.Constant.SourceRepresentation(), alignRight},
+		column{";", alignLeft})
+	if o.InlineComment != nil {
+		cols = append(cols, column{text: " //"}, column{text: o.InlineComment.Message()})
+	}
+	return
+}
+
+// rpcColumns returns columns of rpc, options of rpc are printed in a block ending the rpc
+func rpcColumns(rpc *proto.RPC) (cols []column) {
+	stream := func(streams bool) column {
+		if streams {
+			return column{"stream ", alignLeft}
+		}
+		return column{"", alignLeft}
+	}
+	cols = append(cols, column{"rpc ", alignLeft}, column{rpc.Name, alignLeft},
+		column{" (", alignLeft}, stream(rpc.StreamsRequest), column{rpc.RequestType, alignLeft},
+		column{") ", alignLeft}, column{"returns", alignLeft}, column{" (", alignLeft},
+		stream(rpc.StreamsReturns), column{rpc.ReturnsType, alignLeft}, column{")", alignLeft})
+
+	var block []string
+	for _, each := range rpc.Elements {
+		o, ok := each.(*proto.Option)
+		if !ok {
+			continue
+		}
+		if o.AggregatedConstants == nil {
+			block = append(block, fmt.Sprintf("%soption %s = %s;", indentSeparator, o.Name,
+				o.Constant.SourceRepresentation()))
+			continue
+		}
+		block = append(block, fmt.Sprintf("%soption %s = {", indentSeparator, o.Name))
+		for _, c := range o.AggregatedConstants {
+			block = append(block, fmt.Sprintf("%s%s%s: %s", indentSeparator, indentSeparator, c.Name,
+				c.Literal.SourceRepresentation()))
+		}
+		block = append(block, indentSeparator+"};")
+	}
+	if len(block) != 0 {
+		cols = append(cols, column{text: " {\n" + strings.Join(block, "\n") + "\n}"})
+	} else {
+		cols = append(cols, column{";", alignLeft})
+	}
+	if rpc.InlineComment != nil {
+		cols = append(cols, column{text: " //"}, column{text: rpc.InlineComment.Message()})
+	}
+	return
+}
+
+// columnAlign defines how column text is padded to the column width
+type columnAlign int
+
+const (
+	// alignNone doesn't pad text, nor affects the column width
+	alignNone columnAlign = iota
+	alignLeft
+	alignRight
+)
+
+// column defines a column of aligned rows
+type column struct {
+	text  string
+	align columnAlign
+}
+
+// printColumns prints rows with aligned columns as protofmt does, lines of unaligned text are
+// indented too and the padding ending a row is trimmed
+func printColumns(buf *bytes.Buffer, indent string, rows [][]column) {
+	var widths []int
+	for _, row := range rows {
+		for i, col := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if col.align != alignNone && len(col.text) > widths[i] {
+				widths[i] = len(col.text)
+			}
+		}
+	}
+	for _, row := range rows {
+		line := bytes.NewBufferString(indent)
+		for i, col := range row {
+			switch col.align {
+			case alignNone:
+				line.WriteString(strings.Replace(col.text, "\n", "\n"+indent, -1))
+			case alignLeft:
+				line.WriteString(col.text + strings.Repeat(" ", widths[i]-len(col.text)))
+			case alignRight:
+				line.WriteString(strings.Repeat(" ", widths[i]-len(col.text)) + col.text)
+			}
+		}
+		buf.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+	}
+}
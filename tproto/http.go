@@ -0,0 +1,124 @@
+package tproto
+
+import (
+	"go/ast"
+	"regexp"
+	"strings"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+)
+
+// HTTPDecorator defines the directive of service methods which maps rpc to HTTP for
+// grpc-gateway, e.g. "@http GET /v1/users/{id}" or "@http POST /v1/users *" where the last
+// arg is the request field mapped to the HTTP body
+const HTTPDecorator = "@http"
+
+// httpOption defines the rpc option of HTTP rule
+const httpOption = "(google.api.http)"
+
+// httpMethods maps HTTP methods to patterns of HTTP rule
+var httpMethods = map[string]string{
+	"GET": "get", "PUT": "put", "POST": "post", "DELETE": "delete", "PATCH": "patch",
+}
+
+// pathVariable matches variables of HTTP path template, e.g. {id} and {name=users/*}
+var pathVariable = regexp.MustCompile(`{([^}=]*)(=[^}]*)?}`)
+
+// httpRuleOption parses the HTTP directive in doc into the HTTP rule option of rpc, path
+// variables and body must be fields of the request message. It returns nil if there is no
+// directive
+func (t *Parser) httpRuleOption(rpc *proto.RPC, doc *ast.CommentGroup) (option *proto.Option,
+	err error) {
+	args := decoratorArgs(doc, HTTPDecorator)
+	if args == nil {
+		return
+	}
+	if len(args) < 2 || len(args) > 3 {
+		err = errors.Errorf("invalid %s directive, want %s METHOD PATH [BODY]", HTTPDecorator,
+			HTTPDecorator)
+		return
+	}
+	method, path := args[0], args[1]
+	pattern, ok := httpMethods[strings.ToUpper(method)]
+	if !ok {
+		err = errors.Errorf("unsupported HTTP method %s", method)
+		return
+	}
+	if !strings.HasPrefix(path, "/") {
+		err = errors.Errorf("invalid HTTP path %s, want /...", path)
+		return
+	}
+	for _, match := range pathVariable.FindAllStringSubmatch(path, -1) {
+		typeStr, repeated, ok := t.fieldPathType(rpc.RequestType, match[1])
+		if !ok {
+			err = errors.Errorf("path variable %s is not a field of %s", match[1], rpc.RequestType)
+			return
+		}
+		if repeated {
+			err = errors.Errorf("path variable %s is a repeated or map field, want singular",
+				match[1])
+			return
+		}
+		if _, isEnum := t.enums[typeStr]; !protoScalarTypes[typeStr] && !isEnum {
+			err = errors.Errorf("path variable %s is %s, want scalar or enum", match[1], typeStr)
+			return
+		}
+	}
+
+	option = &proto.Option{
+		Name: httpOption,
+		AggregatedConstants: []*proto.NamedLiteral{
+			{Name: pattern, Literal: &proto.Literal{Source: path, IsString: true}},
+		},
+	}
+	if len(args) == 3 {
+		body := args[2]
+		if pattern == "get" {
+			err = errors.Errorf("HTTP method %s has no body", method)
+			return
+		}
+		if body != "*" {
+			// body is a top-level field, which may be repeated
+			if _, _, ok := t.fieldPathType(rpc.RequestType, body); !ok || strings.Contains(body, ".") {
+				err = errors.Errorf("body %s is not a field of %s", body, rpc.RequestType)
+				return
+			}
+		}
+		option.AggregatedConstants = append(option.AggregatedConstants, &proto.NamedLiteral{
+			Name: "body", Literal: &proto.Literal{Source: body, IsString: true}})
+	}
+	return
+}
+
+// fieldPathType returns the type of field path in message, e.g. user.id, repeated reports
+// whether any field of the path is a repeated or map field
+func (t *Parser) fieldPathType(msgName, fieldPath string) (typeStr string, repeated, ok bool) {
+	typeStr = msgName
+	for _, name := range strings.Split(fieldPath, ".") {
+		msg, isMessage := t.messages[typeStr]
+		if !isMessage {
+			return "", false, false
+		}
+		found := false
+		for _, each := range msg.Elements {
+			for _, f := range visiteeFields(each) {
+				if f.Name != name {
+					continue
+				}
+				typeStr, found = f.Type, true
+				switch field := each.(type) {
+				case *proto.MapField:
+					repeated = true
+				case *proto.NormalField:
+					repeated = repeated || field.Repeated
+				}
+			}
+		}
+		if !found {
+			return "", false, false
+		}
+	}
+	ok = true
+	return
+}
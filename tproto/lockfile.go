@@ -0,0 +1,241 @@
+package tproto
+
+import (
+	"encoding/json"
+	"go/ast"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultLockFile defines the default path of schema lockfile
+const DefaultLockFile = "tproto.lock"
+
+// Lock defines the schema lockfile, which keeps field numbers and enum values across
+// generations without a proto file. It's written as YAML if the path ends with .yaml or .yml,
+// otherwise JSON
+type Lock struct {
+	Messages map[string]*LockMessage `json:"messages" yaml:"messages"`
+	Enums    map[string]*LockEnum    `json:"enums,omitempty" yaml:"enums,omitempty"`
+}
+
+// LockMessage defines the locked message
+type LockMessage struct {
+	// GoType is the golang type of message, e.g. github.com/wy-z/tproto/samples.BasicTypes
+	GoType string `json:"go_type,omitempty" yaml:"go_type,omitempty"`
+	// Fields records every field number ever assigned, keyed by field name
+	Fields map[string]int `json:"fields" yaml:"fields"`
+	// Reserved records numbers of removed fields and other reserved numbers
+	Reserved []int `json:"reserved,omitempty" yaml:"reserved,omitempty"`
+}
+
+// LockEnum defines the locked enum
+type LockEnum struct {
+	// Values records every number ever assigned to values of string enums, keyed by value
+	// name, so that numbers of removed values are not reused
+	Values map[string]int `json:"values" yaml:"values"`
+}
+
+// NewLock returns an empty lock
+func NewLock() *Lock {
+	return &Lock{Messages: make(map[string]*LockMessage), Enums: make(map[string]*LockEnum)}
+}
+
+// LoadLockFile loads schema lockfile, parsed messages and string enums keep numbers of locked
+// ones unless they are loaded from proto file
+func (t *Parser) LoadLockFile(path string) (err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	lock := NewLock()
+	if isYAMLFile(path) {
+		err = yaml.Unmarshal(data, lock)
+	} else {
+		err = json.Unmarshal(data, lock)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "invalid lockfile %s", path)
+		return
+	}
+	if lock.Messages == nil {
+		lock.Messages = make(map[string]*LockMessage)
+	}
+	if lock.Enums == nil {
+		lock.Enums = make(map[string]*LockEnum)
+	}
+	t.schemaLock = lock
+	return
+}
+
+// WriteLockFile updates schema lockfile by all messages and enums, locked ones which are not
+// parsed anymore are kept
+func (t *Parser) WriteLockFile(path string) (err error) {
+	lock := t.SchemaLock()
+	var data []byte
+	if isYAMLFile(path) {
+		data, err = yaml.Marshal(lock)
+	} else {
+		data, err = json.MarshalIndent(lock, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	return
+}
+
+// SchemaLock returns the loaded lock updated by all messages and enums
+func (t *Parser) SchemaLock() (lock *Lock) {
+	lock = NewLock()
+	if t.schemaLock != nil {
+		for name, locked := range t.schemaLock.Messages {
+			lock.Messages[name] = locked
+		}
+		for name, locked := range t.schemaLock.Enums {
+			lock.Enums[name] = locked
+		}
+	}
+	for name, enum := range t.enums {
+		locked := &LockEnum{Values: make(map[string]int)}
+		if old, ok := lock.Enums[name]; ok {
+			for valueName, n := range old.Values {
+				locked.Values[valueName] = n
+			}
+		}
+		for _, each := range enum.Elements {
+			if f, ok := each.(*proto.EnumField); ok {
+				locked.Values[f.Name] = f.Integer
+			}
+		}
+		lock.Enums[name] = locked
+	}
+	for name, msg := range t.messages {
+		locked := &LockMessage{GoType: t.goTypes[name], Fields: make(map[string]int)}
+		if old, ok := lock.Messages[name]; ok {
+			if locked.GoType == "" {
+				locked.GoType = old.GoType
+			}
+			for fieldName, n := range old.Fields {
+				locked.Fields[fieldName] = n
+			}
+		}
+
+		present := make(map[int]bool)
+		for _, f := range messageFields(msg) {
+			locked.Fields[f.Name] = f.Sequence
+			present[f.Sequence] = true
+		}
+		reserved := make(map[int]bool)
+		for _, n := range locked.Fields {
+			if !present[n] {
+				reserved[n] = true
+			}
+		}
+		ranges, _ := messageReserved(msg)
+		for _, r := range ranges {
+			if r.Max {
+				continue
+			}
+			for n := r.From; n <= r.To; n++ {
+				reserved[n] = true
+			}
+		}
+		for n := range reserved {
+			locked.Reserved = append(locked.Reserved, n)
+		}
+		sort.Ints(locked.Reserved)
+		lock.Messages[name] = locked
+	}
+	return
+}
+
+// lockedMessage returns the locked message as a proto message, removed fields are kept as
+// fields so that they get their numbers back when added again
+func (t *Parser) lockedMessage(title string) (message *proto.Message) {
+	if t.schemaLock == nil {
+		return
+	}
+	locked, ok := t.schemaLock.Messages[title]
+	if !ok {
+		return
+	}
+
+	message = new(proto.Message)
+	message.Name = title
+	names := make([]string, 0, len(locked.Fields))
+	numbers := make(map[int]bool)
+	for name, n := range locked.Fields {
+		names = append(names, name)
+		numbers[n] = true
+	}
+	sort.Strings(names)
+	var ranges []proto.Range
+	for _, n := range locked.Reserved {
+		if !numbers[n] {
+			ranges = append(ranges, proto.Range{From: n, To: n})
+		}
+	}
+	if len(ranges) != 0 {
+		message.Elements = append(message.Elements, &proto.Reserved{Ranges: ranges})
+	}
+	for _, name := range names {
+		f := new(proto.Field)
+		f.Name = name
+		f.Sequence = locked.Fields[name]
+		message.Elements = append(message.Elements, &proto.NormalField{Field: f})
+	}
+	return
+}
+
+// lockedEnum returns the locked enum as a proto enum, removed values are kept as values so
+// that their numbers are not reused
+func (t *Parser) lockedEnum(name string) (enum *proto.Enum) {
+	if t.schemaLock == nil {
+		return
+	}
+	locked, ok := t.schemaLock.Enums[name]
+	if !ok {
+		return
+	}
+
+	enum = new(proto.Enum)
+	enum.Name = name
+	names := make([]string, 0, len(locked.Values))
+	for valueName := range locked.Values {
+		names = append(names, valueName)
+	}
+	sort.Strings(names)
+	for _, valueName := range names {
+		enum.Elements = append(enum.Elements, &proto.EnumField{
+			Name:    valueName,
+			Integer: locked.Values[valueName],
+		})
+	}
+	return
+}
+
+// goTypeName returns the qualified golang type name of struct, empty for anonymous structs
+func (t *Parser) goTypeName(pkg *ast.Package, st *ast.StructType) string {
+	ts := t.structTypeSpec(pkg, st)
+	if ts == nil {
+		return ""
+	}
+	return t.identity(pkg, ts.Name.Name)
+}
+
+func isYAMLFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
@@ -0,0 +1,138 @@
+package tproto
+
+import (
+	"go/ast"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+)
+
+// NamespaceMode defines how types declared outside the parsed package are named
+type NamespaceMode string
+
+const (
+	// NamespaceNone names types by their golang type names
+	NamespaceNone NamespaceMode = ""
+	// NamespacePrefix prefixes type names by their package names, e.g. BillingAccount
+	NamespacePrefix NamespaceMode = "prefix"
+	// NamespaceNested nests types in messages named after their packages, e.g. Billing.Account
+	NamespaceNested NamespaceMode = "nested"
+)
+
+// pkgPath returns the import path of package, or the package name if unknown
+func (t *Parser) pkgPath(pkg *ast.Package) string {
+	if pkgPath, ok := t.pkgPaths[pkg]; ok {
+		return pkgPath
+	}
+	return pkg.Name
+}
+
+// identity returns the qualified identity of type title, which is unique across packages
+func (t *Parser) identity(pkg *ast.Package, title string) string {
+	return t.pkgPath(pkg) + "." + title
+}
+
+// typeTitle returns the title of named type, which is renamed by ParserOptions.Renames or
+// disambiguated by ParserOptions.Namespace if declared outside the parsed package
+func (t *Parser) typeTitle(pkg *ast.Package, name string) string {
+	if title, ok := t.opts.Renames[t.identity(pkg, name)]; ok {
+		return title
+	}
+	if pkg == t.root || t.opts.PackageFiles {
+		return name
+	}
+	switch t.opts.Namespace {
+	case NamespacePrefix:
+		return camelCase(pkg.Name) + name
+	case NamespaceNested:
+		return camelCase(pkg.Name) + "." + name
+	}
+	return name
+}
+
+// registerType registers the proto name of type title, different types must not share
+// the same proto name, and a namespace must not share the proto name of a type
+func (t *Parser) registerType(pkg *ast.Package, title, name string) (err error) {
+	identity := t.identity(pkg, title)
+	if other, ok := t.identities[name]; ok && other != identity {
+		err = errors.Errorf("proto name %s of %s conflicts with %s, rename one of them",
+			name, identity, other)
+		return
+	}
+	namespace, _ := namespaceOf(name)
+	if namespace != "" {
+		if other, ok := t.identities[namespace]; ok {
+			err = errors.Errorf("namespace %s of %s conflicts with %s, rename one of them",
+				namespace, identity, other)
+			return
+		}
+		if _, ok := t.namespaces[namespace]; !ok {
+			t.namespaces[namespace] = identity
+		}
+	} else if other, ok := t.namespaces[name]; ok {
+		err = errors.Errorf("proto name %s of %s conflicts with namespace of %s, rename one of them",
+			name, identity, other)
+		return
+	}
+	t.identities[name] = identity
+	t.owners[name] = pkg
+	return
+}
+
+// namespaceOf returns the namespace and the nested name of proto name
+func namespaceOf(name string) (namespace, nested string) {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// nestNamespaces moves enums and messages named with namespaces into messages named after
+// the namespaces, parsed enums and messages are kept as they are. Namespaces never share
+// names of parsed messages, which registerType rejects
+func nestNamespaces(elements []proto.Visitee) (nested []proto.Visitee) {
+	members := make(map[string][]proto.Visitee)
+	var namespaces []string
+	for _, each := range elements {
+		switch v := each.(type) {
+		case *proto.Enum:
+			if namespace, name := namespaceOf(v.Name); namespace != "" {
+				e := *v
+				e.Name = name
+				members[namespace] = append(members[namespace], &e)
+				continue
+			}
+		case *proto.Message:
+			if namespace, name := namespaceOf(v.Name); namespace != "" {
+				m := *v
+				m.Name = name
+				members[namespace] = append(members[namespace], &m)
+				continue
+			}
+		}
+	}
+	for namespace := range members {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, each := range elements {
+		switch v := each.(type) {
+		case *proto.Enum:
+			if namespace, _ := namespaceOf(v.Name); namespace != "" {
+				continue
+			}
+		case *proto.Message:
+			if namespace, _ := namespaceOf(v.Name); namespace != "" {
+				continue
+			}
+		}
+		nested = append(nested, each)
+	}
+	for _, namespace := range namespaces {
+		nested = append(nested, &proto.Message{Name: namespace, Elements: members[namespace]})
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package tproto
+
+import (
+	"go/ast"
+	"strings"
+	"unicode"
+)
+
+// protoTypeName returns the proto name of message or enum, titles are joined by underscores.
+// Names are qualified by proto packages if ParserOptions.PackageFiles
+func (t *Parser) protoTypeName(pkg *ast.Package, title string) (name string) {
+	name = title
+	if t.opts.CamelCaseMessages {
+		name = camelCase(title)
+	}
+	if t.opts.PackageFiles {
+		name = t.protoPackage(pkg) + "." + name
+	}
+	return
+}
+
+// protoFieldName returns the proto name of field
+func (t *Parser) protoFieldName(name string) string {
+	if !t.opts.SnakeCaseFields {
+		return name
+	}
+	return snakeCase(name)
+}
+
+// protoEnumValueName returns the proto name of enum value, upper snake case values are
+// prefixed by the enum name as the style guide suggests
+func (t *Parser) protoEnumValueName(enumName, name string) string {
+	if !t.opts.UpperSnakeEnumValues {
+		return name
+	}
+	prefix := strings.ToUpper(snakeCase(enumName)) + "_"
+	name = strings.ToUpper(snakeCase(name))
+	if !strings.HasPrefix(name, prefix) {
+		name = prefix + name
+	}
+	return name
+}
+
+// snakeCase converts CamelCase or lowerCamelCase name into snake_case,
+// e.g. UserID -> user_id, HTTPServer -> http_server, Int8Field -> int8_field
+func snakeCase(name string) string {
+	runes := []rune(name)
+	buf := make([]rune, 0, len(runes)+4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && runes[i-1] != '_' {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				buf = append(buf, '_')
+			}
+		}
+		buf = append(buf, unicode.ToLower(r))
+	}
+	return string(buf)
+}
+
+// camelCase converts name joined by underscores into CamelCase,
+// e.g. StructWithAnonymousField_AnonymousMap -> StructWithAnonymousFieldAnonymousMap
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		parts[i] = string(runes)
+	}
+	return strings.Join(parts, "")
+}
+
+// fieldTagName returns the proto name of field, name option of FieldTagName is used as is
+func (t *Parser) fieldTagName(tag fieldTag, jsonName string) string {
+	if tag.name != "" {
+		return tag.name
+	}
+	return t.protoFieldName(jsonName)
+}
@@ -0,0 +1,162 @@
+package tproto
+
+import (
+	"sort"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+)
+
+// Field numbers reserved by the protobuf implementation and the largest field number
+const (
+	firstReservedFieldNumber = 19000
+	lastReservedFieldNumber  = 19999
+	maxFieldNumber           = 1<<29 - 1
+)
+
+// messageFields returns all fields of message, including oneof members
+func messageFields(msg *proto.Message) (fields []*proto.Field) {
+	for _, each := range msg.Elements {
+		fields = append(fields, visiteeFields(each)...)
+	}
+	return
+}
+
+// visiteeFields returns fields of message element, a oneof has a field per member
+func visiteeFields(v proto.Visitee) (fields []*proto.Field) {
+	switch f := v.(type) {
+	case *proto.NormalField:
+		fields = append(fields, f.Field)
+	case *proto.MapField:
+		fields = append(fields, f.Field)
+	case *proto.Oneof:
+		for _, e := range f.Elements {
+			if of, ok := e.(*proto.OneOfField); ok {
+				fields = append(fields, of.Field)
+			}
+		}
+	}
+	return
+}
+
+// messageReserved returns reserved numbers and names of message
+func messageReserved(msg *proto.Message) (ranges []proto.Range, names []string) {
+	for _, each := range msg.Elements {
+		if r, ok := each.(*proto.Reserved); ok {
+			ranges = append(ranges, r.Ranges...)
+			names = append(names, r.FieldNames...)
+		}
+	}
+	return
+}
+
+// stabilizeFieldNumbers reuses field numbers of the previous message, new fields get the
+// next free numbers and removed fields are reserved, so that the wire format is kept.
+// Fixed fields keep their numbers, which must not be reserved by the previous message
+func stabilizeFieldNumbers(message, previous *proto.Message, fixed map[string]bool) (err error) {
+	if previous == nil {
+		return
+	}
+
+	numbers := make(map[string]int)
+	maxNumber := 0
+	for _, f := range messageFields(previous) {
+		numbers[f.Name] = f.Sequence
+		if f.Sequence > maxNumber {
+			maxNumber = f.Sequence
+		}
+	}
+	ranges, names := messageReserved(previous)
+	for _, r := range ranges {
+		if !r.Max && r.To > maxNumber {
+			maxNumber = r.To
+		}
+	}
+	isReserved := func(n int) bool {
+		if n >= firstReservedFieldNumber && n <= lastReservedFieldNumber {
+			return true
+		}
+		return isReservedNumber(ranges, n)
+	}
+
+	present := make(map[string]bool)
+	taken := make(map[int]bool)
+	for _, f := range messageFields(message) {
+		present[f.Name] = true
+		if !fixed[f.Name] {
+			continue
+		}
+		if isReserved(f.Sequence) {
+			err = errors.Errorf("field number %d of %s.%s is reserved", f.Sequence, message.Name,
+				f.Name)
+			return
+		}
+		taken[f.Sequence] = true
+	}
+	for _, f := range messageFields(message) {
+		if fixed[f.Name] {
+			continue
+		}
+		if n, ok := numbers[f.Name]; ok && !taken[n] {
+			f.Sequence = n
+			continue
+		}
+		maxNumber++
+		for (isReserved(maxNumber) || taken[maxNumber]) && maxNumber < maxFieldNumber {
+			maxNumber++
+		}
+		f.Sequence = maxNumber
+	}
+
+	reservedNames := make(map[string]bool)
+	for _, name := range names {
+		// a field coming back is not removed anymore, but its number is kept reserved
+		if !present[name] {
+			reservedNames[name] = true
+		}
+	}
+	for name, n := range numbers {
+		if present[name] {
+			continue
+		}
+		reservedNames[name] = true
+		// the number may be taken over by a fixed field on purpose
+		if !taken[n] {
+			ranges = append(ranges, proto.Range{From: n, To: n})
+		}
+	}
+	elements := make([]proto.Visitee, 0, len(message.Elements)+2)
+	if len(ranges) != 0 {
+		elements = append(elements, &proto.Reserved{Ranges: mergeRanges(ranges)})
+	}
+	if len(reservedNames) != 0 {
+		r := new(proto.Reserved)
+		for name := range reservedNames {
+			r.FieldNames = append(r.FieldNames, name)
+		}
+		sort.Strings(r.FieldNames)
+		elements = append(elements, r)
+	}
+	message.Elements = append(elements, message.Elements...)
+	return
+}
+
+// mergeRanges sorts ranges and merges the overlapping or adjacent ones
+func mergeRanges(ranges []proto.Range) (merged []proto.Range) {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].From < ranges[j].From
+	})
+	for _, r := range ranges {
+		last := len(merged) - 1
+		if last >= 0 && (merged[last].Max || r.From <= merged[last].To+1) {
+			if r.Max {
+				merged[last].Max = true
+			} else if !merged[last].Max && r.To > merged[last].To {
+				merged[last].To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return
+}
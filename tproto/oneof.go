@@ -0,0 +1,300 @@
+package tproto
+
+import (
+	"go/ast"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+)
+
+// OneofDecorator defines the decorator listing implementations of a sealed interface,
+// e.g. "// @oneof Circle Square"
+const OneofDecorator = "@oneof"
+
+// typeMethods collects method names of all types in package, keyed by receiver type name
+func (t *Parser) typeMethods(pkg *ast.Package) (methods map[string]map[string]bool) {
+	if methods, ok := t.methods[pkg]; ok {
+		return methods
+	}
+
+	methods = make(map[string]map[string]bool)
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+				continue
+			}
+			ident, ok := starExprX(funcDecl.Recv.List[0].Type).(*ast.Ident)
+			if !ok {
+				continue
+			}
+			if methods[ident.Name] == nil {
+				methods[ident.Name] = make(map[string]bool)
+			}
+			methods[ident.Name][funcDecl.Name.Name] = true
+		}
+	}
+	t.methods[pkg] = methods
+	return
+}
+
+// implementers finds struct implementations of a sealed interface, which are listed by
+// OneofDecorator, or implement all methods of an interface declaring an unexported marker
+// method in the same package
+func (t *Parser) implementers(pkg *ast.Package, ts *ast.TypeSpec) (impls []*ast.TypeSpec, err error) {
+	it, ok := ts.Type.(*ast.InterfaceType)
+	if !ok {
+		return
+	}
+	objs, err := t.loader.ParsePkg(pkg)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	if names := decoratorArgs(typeSpecDoc(pkg, ts), OneofDecorator); names != nil {
+		for _, name := range names {
+			obj, ok := objs[name]
+			if !ok {
+				err = errors.Errorf("implementation %s of %s not found", name, ts.Name.Name)
+				return
+			}
+			impl, e := objDeclTypeSpec(obj)
+			if e != nil {
+				err = errors.WithStack(e)
+				return
+			}
+			if _, isStruct := starExprX(impl.Type).(*ast.StructType); !isStruct {
+				err = errors.Errorf("implementation %s of %s is not a struct", name, ts.Name.Name)
+				return
+			}
+			impls = append(impls, impl)
+		}
+	} else {
+		names, e := t.interfaceMethods(pkg, it)
+		if e != nil {
+			err = errors.WithStack(e)
+			return
+		}
+		if !hasMarkerMethod(names) {
+			return
+		}
+		for typeName, methods := range t.typeMethods(pkg) {
+			obj, ok := objs[typeName]
+			if !ok {
+				continue
+			}
+			impl, e := objDeclTypeSpec(obj)
+			if e != nil {
+				continue
+			}
+			if _, isStruct := starExprX(impl.Type).(*ast.StructType); !isStruct {
+				continue
+			}
+			implemented := true
+			for _, name := range names {
+				implemented = implemented && methods[name]
+			}
+			if implemented {
+				impls = append(impls, impl)
+			}
+		}
+	}
+	sort.Slice(impls, func(i, j int) bool {
+		return impls[i].Name.Name < impls[j].Name.Name
+	})
+	return
+}
+
+// interfaceMethods returns method names of interface, including methods of embedded interfaces
+func (t *Parser) interfaceMethods(pkg *ast.Package, it *ast.InterfaceType) (names []string,
+	err error) {
+	for _, m := range it.Methods.List {
+		if len(m.Names) != 0 {
+			for _, ident := range m.Names {
+				names = append(names, ident.Name)
+			}
+			continue
+		}
+
+		// embedded interface
+		epkg, ets := pkg, (*ast.TypeSpec)(nil)
+		switch v := m.Type.(type) {
+		case *ast.Ident:
+			if v.Name == "error" && v.Obj == nil {
+				names = append(names, "Error")
+				continue
+			}
+			epkg, ets, err = t.lookupType(pkg, v.Name)
+		case *ast.SelectorExpr:
+			pkgPath := t.selectorImportPath(pkg, v)
+			if pkgPath == "" {
+				err = errors.Errorf("package of %s not found", selectorExprTypeStr(v))
+				return
+			}
+			epkg, err = t.importPkg(pkgPath)
+			if err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			t.pkgPaths[epkg] = pkgPath
+			epkg, ets, err = t.lookupType(epkg, v.Sel.Name)
+		}
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+		if ets == nil {
+			continue
+		}
+		eit, ok := ets.Type.(*ast.InterfaceType)
+		if !ok {
+			continue
+		}
+		embedded, e := t.interfaceMethods(epkg, eit)
+		if e != nil {
+			err = errors.WithStack(e)
+			return
+		}
+		names = append(names, embedded...)
+	}
+	return
+}
+
+// hasMarkerMethod checks whether method names include an unexported one, which seals the
+// interface to its package
+func hasMarkerMethod(names []string) bool {
+	for _, name := range names {
+		if !ast.IsExported(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSealedInterface checks whether the named type is an interface with known implementations
+func (t *Parser) isSealedInterface(pkg *ast.Package, ts *ast.TypeSpec) bool {
+	if _, ok := ts.Type.(*ast.InterfaceType); !ok {
+		return false
+	}
+	impls, err := t.implementers(pkg, ts)
+	return err != nil || len(impls) != 0
+}
+
+// sealedInterface returns type spec of ident if it's a sealed interface
+func (t *Parser) sealedInterface(pkg *ast.Package, ident *ast.Ident) (ts *ast.TypeSpec, ok bool) {
+	if ident.Obj == nil {
+		return
+	}
+	ts, err := objDeclTypeSpec(ident.Obj)
+	if err != nil {
+		return
+	}
+	_, ok = ts.Type.(*ast.InterfaceType)
+	return
+}
+
+// parseOneof parses sealed interface into oneof, members are named after the oneof and their
+// types, e.g. From_Circle, since they share the field namespace of message with members of
+// other oneofs of the same interface. Members are numbered from sequence
+func (t *Parser) parseOneof(pkg *ast.Package, ts *ast.TypeSpec, name string, sequence int) (
+	oneof *proto.Oneof, err error) {
+	impls, err := t.implementers(pkg, ts)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	oneof = new(proto.Oneof)
+	oneof.Name = name
+	for i, impl := range impls {
+		title := t.typeTitle(pkg, impl.Name.Name)
+		err = t.parseMessage(pkg, starExprX(impl.Type).(*ast.StructType), title)
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+		f := new(proto.Field)
+		f.Name = name + "_" + t.protoFieldName(impl.Name.Name)
+		f.Type = t.protoTypeName(pkg, title)
+		f.Sequence = sequence + i
+		oneof.Elements = append(oneof.Elements, &proto.OneOfField{Field: f})
+	}
+	return
+}
+
+// parseOneofMessage parses sealed interface into a message holding the oneof,
+// it's used where a oneof can't be, e.g. repeated fields and map values
+func (t *Parser) parseOneofMessage(pkg *ast.Package, ts *ast.TypeSpec, title string) (err error) {
+	if t.parsed[t.identity(pkg, title)] {
+		return
+	}
+	t.parsed[t.identity(pkg, title)] = true
+
+	message := new(proto.Message)
+	message.Name = t.protoTypeName(pkg, title)
+	doc := typeDoc(pkg, ts)
+	message.Comment = t.protoComment(doc)
+	if isDeprecated(doc) {
+		message.Elements = append(message.Elements, deprecatedOption(false))
+	}
+	err = t.registerType(pkg, title, message.Name)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	oneof, err := t.parseOneof(pkg, ts, t.protoFieldName(ts.Name.Name), 1)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	message.Elements = append(message.Elements, oneof)
+	previous := t.messages[message.Name]
+	if previous == nil {
+		previous = t.lockedMessage(message.Name)
+	}
+	err = stabilizeFieldNumbers(message, previous, nil)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	t.messages[message.Name] = message
+	return
+}
+
+// typeSpecDoc returns doc of type spec, including doc of its decl
+func typeSpecDoc(pkg *ast.Package, ts *ast.TypeSpec) *ast.CommentGroup {
+	if ts.Doc != nil {
+		return ts.Doc
+	}
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Doc == nil {
+				continue
+			}
+			for _, s := range genDecl.Specs {
+				if s == ts {
+					return genDecl.Doc
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decoratorArgs returns args of the decorator line in doc, nil means not found
+func decoratorArgs(doc *ast.CommentGroup, decorator string) (args []string) {
+	if doc == nil {
+		return
+	}
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == decorator {
+			return append([]string{}, fields[1:]...)
+		}
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package tproto
+
+import (
+	"encoding/json"
+	"go/ast"
+	"io/ioutil"
+	"sort"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultGoPackageSuffix defines the default suffix of derived go_package, generated golang
+// code can't live in the package it's generated from
+const DefaultGoPackageSuffix = "/pb"
+
+// FileOptions defines options of rendered proto files
+type FileOptions struct {
+	// GoPackage is the go_package option, it's derived from the golang import path and
+	// GoPackageSuffix if empty. With ParserOptions.PackageFiles it's the option of the parsed
+	// package only, files of other packages derive theirs
+	GoPackage string `json:"go_package,omitempty" yaml:"go_package,omitempty"`
+	// GoPackageSuffix is appended to the golang import path to derive go_package
+	GoPackageSuffix string `json:"go_package_suffix,omitempty" yaml:"go_package_suffix,omitempty"`
+	// JavaPackage is the java_package option
+	JavaPackage string `json:"java_package,omitempty" yaml:"java_package,omitempty"`
+	// CsharpNamespace is the csharp_namespace option
+	CsharpNamespace string `json:"csharp_namespace,omitempty" yaml:"csharp_namespace,omitempty"`
+	// Options maps other options, including custom ones, to constants in proto syntax,
+	// e.g. java_multiple_files: true, objc_class_prefix: "\"TP\"", (my.option): 1
+	Options map[string]string `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+// LoadFileOptions loads file options from config file, which is YAML if the path ends with
+// .yaml or .yml, otherwise JSON. Options missing in the file are the default ones
+func LoadFileOptions(path string) (opts FileOptions, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	opts = DefaultParserOptions.FileOptions
+	if isYAMLFile(path) {
+		err = yaml.Unmarshal(data, &opts)
+	} else {
+		err = json.Unmarshal(data, &opts)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "invalid file options %s", path)
+		return
+	}
+	return
+}
+
+// fileOptions returns options of the proto file of golang package, go_package is not
+// derived if package is unknown, and the given one is kept for the parsed package only if
+// rendered per package. Options required by the syntax are the last
+func (t *Parser) fileOptions(pkg *ast.Package) (options []proto.Visitee) {
+	opts := t.opts.FileOptions
+	stringOption := func(name, value string) {
+		if value != "" {
+			options = append(options, &proto.Option{
+				Name:     name,
+				Constant: proto.Literal{Source: value, IsString: true},
+			})
+		}
+	}
+
+	goPackage := opts.GoPackage
+	if pkg != nil && (goPackage == "" || (t.opts.PackageFiles && pkg != t.root)) {
+		goPackage = t.pkgPath(pkg) + opts.GoPackageSuffix
+	}
+	stringOption("go_package", goPackage)
+	stringOption("java_package", opts.JavaPackage)
+	stringOption("csharp_namespace", opts.CsharpNamespace)
+
+	names := make([]string, 0, len(opts.Options))
+	for name := range opts.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		options = append(options, &proto.Option{
+			Name:     name,
+			Constant: proto.Literal{Source: opts.Options[name]},
+		})
+	}
+	options = append(options, t.syntaxFileOptions()...)
+	return
+}
@@ -0,0 +1,220 @@
+package tproto
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// recordFieldTag records the field number of a source field of the parsed package, which
+// will be written by WriteFieldTags. Fields of embedded structs are written only if every
+// embedding struct numbers them the same, since the written number holds for all of them.
+// Fields which can't be tagged are reported by WriteFieldTags
+func (t *Parser) recordFieldTag(field *goField, fieldProto proto.Visitee) {
+	if fieldProto == nil || field.pkg != t.root || field.field == nil {
+		return
+	}
+	if len(field.field.Names) > 1 {
+		t.fieldTagSkips = append(t.fieldTagSkips, fmt.Sprintf(
+			"field number of %s not written, fields sharing a declaration can't be tagged",
+			field.name))
+		return
+	}
+	fields := visiteeFields(fieldProto)
+	for i, f := range fields {
+		// oneof members are numbered consecutively from the tagged number
+		if f.Sequence != fields[0].Sequence+i {
+			t.fieldTagSkips = append(t.fieldTagSkips, fmt.Sprintf(
+				"field number of %s not written, oneof members aren't consecutive", field.name))
+			return
+		}
+	}
+
+	pos := t.fset.Position(field.field.Pos())
+	if t.fieldTags[pos.Filename] == nil {
+		t.fieldTags[pos.Filename] = make(map[int]int)
+	}
+	numbers := t.fieldTags[pos.Filename]
+	if n, ok := numbers[pos.Offset]; ok && n != fields[0].Sequence {
+		// zero marks fields numbered differently by embedding structs
+		if n != 0 {
+			t.fieldTagSkips = append(t.fieldTagSkips, fmt.Sprintf(
+				"field number of %s.%s not written, embedding structs number it differently",
+				field.owner, field.name))
+		}
+		numbers[pos.Offset] = 0
+		return
+	}
+	numbers[pos.Offset] = fields[0].Sequence
+	return
+}
+
+// WriteFieldTags rewrites golang source files of parsed structs, so that each field carries
+// its field number in FieldTagName, comments are kept and the files are formatted by gofmt.
+// It returns the changed files, fields which can't be tagged are warned
+func (t *Parser) WriteFieldTags() (files []string, err error) {
+	for _, skip := range t.fieldTagSkips {
+		log.Warn(skip)
+	}
+	names := make([]string, 0, len(t.fieldTags))
+	for name := range t.fieldTags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		changed, e := writeFileFieldTags(name, t.fieldTags[name])
+		if e != nil {
+			err = errors.Wrapf(e, "failed to write field tags to %s", name)
+			return
+		}
+		if changed {
+			files = append(files, name)
+		}
+	}
+	return
+}
+
+// writeFileFieldTags writes field numbers keyed by field offsets into golang source file
+func writeFileFieldTags(path string, numbers map[int]int) (changed bool, err error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		st, ok := node.(*ast.StructType)
+		if !ok || st.Fields == nil {
+			return true
+		}
+		for _, field := range st.Fields.List {
+			n := numbers[fset.Position(field.Pos()).Offset]
+			if n == 0 {
+				continue
+			}
+			tag := ""
+			if field.Tag != nil {
+				tag, err = strconv.Unquote(field.Tag.Value)
+				if err != nil {
+					err = errors.WithStack(err)
+					return false
+				}
+			}
+			tag, err = setTagNumber(tag, n)
+			if err != nil {
+				return false
+			}
+			if field.Tag == nil {
+				field.Tag = &ast.BasicLit{ValuePos: field.Type.End(), Kind: token.STRING}
+			}
+			field.Tag.Value = "`" + tag + "`"
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = format.Node(buf, fset, file)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if bytes.Equal(buf.Bytes(), src) {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	err = ioutil.WriteFile(path, buf.Bytes(), info.Mode())
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	changed = true
+	return
+}
+
+// setTagNumber sets the field number of FieldTagName in struct tag, other keys and options are
+// kept as they are
+func setTagNumber(tag string, n int) (newTag string, err error) {
+	start, end, ok := tagValueSpan(tag, FieldTagName)
+	if !ok {
+		if tag != "" {
+			tag += " "
+		}
+		newTag = fmt.Sprintf("%s%s:%q", tag, FieldTagName, strconv.Itoa(n))
+		return
+	}
+	value, err := strconv.Unquote(tag[start:end])
+	if err != nil {
+		err = errors.Wrapf(err, "invalid struct tag %s", tag)
+		return
+	}
+
+	opts := strings.Split(value, ",")
+	if _, e := strconv.Atoi(strings.TrimSpace(opts[0])); e == nil || strings.TrimSpace(opts[0]) == "" {
+		opts[0] = strconv.Itoa(n)
+	} else {
+		opts = append([]string{strconv.Itoa(n)}, opts...)
+	}
+	newTag = tag[:start] + strconv.Quote(strings.Join(opts, ",")) + tag[end:]
+	return
+}
+
+// tagValueSpan returns the span of the quoted value of key in struct tag, the tag is scanned
+// as reflect.StructTag.Lookup does
+func tagValueSpan(tag, key string) (start, end int, ok bool) {
+	for i := 0; i < len(tag); i = end {
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		nameStart := i
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == nameStart || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return
+		}
+		name := tag[nameStart:i]
+		start = i + 1
+		for i = start + 1; i < len(tag) && tag[i] != '"'; i++ {
+			if tag[i] == '\\' {
+				i++
+			}
+		}
+		if i >= len(tag) {
+			return
+		}
+		end = i + 1
+		if name == key {
+			ok = true
+			return
+		}
+	}
+	return
+}
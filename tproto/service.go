@@ -0,0 +1,357 @@
+package tproto
+
+import (
+	"go/ast"
+	"go/token"
+	"sort"
+
+	"github.com/emicklei/proto"
+	"github.com/pkg/errors"
+)
+
+// rpcSignature describes the supported signature of service methods
+const rpcSignature = "Method(context.Context, *Request) (*Response, error) or its streaming forms"
+
+// rpcMessage defines the request or response of rpc, expr is a pointer to named struct
+type rpcMessage struct {
+	pkg     *ast.Package
+	expr    ast.Expr
+	streams bool
+}
+
+// Services returns all services
+func (t *Parser) Services() map[string]*proto.Service {
+	return t.services
+}
+
+// ParseService parses golang interface into proto service, methods of the interface must
+// look like Method(context.Context, *Request) (*Response, error), request and response
+// structs are parsed into messages.
+//
+// Requests and responses are streamed by channels or stream interfaces, which have
+// Recv() (*Request, error) and/or Send(*Response) error methods:
+//
+//	Method(context.Context, *Request) (<-chan *Response, error)
+//	Method(context.Context, *Request, chan<- *Response) error
+//	Method(context.Context, *Request, SendStream) error
+//	Method(context.Context, <-chan *Request) (*Response, error)
+//	Method(context.Context, RecvStream) (*Response, error)
+//	Method(context.Context, <-chan *Request) (<-chan *Response, error)
+//	Method(context.Context, <-chan *Request, chan<- *Response) error
+//	Method(context.Context, Stream) error
+//
+// Channels of any direction are accepted, the first one is the request. Methods are
+// mapped to HTTP by HTTPDecorator directives
+func (t *Parser) ParseService(pkgPath, typeExpr string) (service *proto.Service, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	pkg, err := t.load(pkgPath)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	tpkg, ts, err := t.lookupType(pkg, typeExpr)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if ts == nil {
+		err = errors.Errorf("%s not found in package %s", typeExpr, pkg.Name)
+		return
+	}
+	it, ok := ts.Type.(*ast.InterfaceType)
+	if !ok {
+		err = errors.Errorf("unsupported type %s, want interface", typeExpr)
+		return
+	}
+
+	title := t.typeTitle(tpkg, ts.Name.Name)
+	service = new(proto.Service)
+	service.Name = t.protoTypeName(tpkg, title)
+	doc := typeDoc(tpkg, ts)
+	service.Comment = t.protoComment(doc)
+	if isDeprecated(doc) {
+		service.Elements = append(service.Elements, deprecatedOption(false))
+	}
+	err = t.registerType(tpkg, title, service.Name)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	for _, method := range it.Methods.List {
+		rpc, e := t.parseRPC(tpkg, title, method)
+		if e != nil {
+			err = errors.WithStack(e)
+			return
+		}
+		service.Elements = append(service.Elements, rpc)
+	}
+	t.services[service.Name] = service
+	return
+}
+
+// parseRPC parses method of service interface into rpc
+func (t *Parser) parseRPC(pkg *ast.Package, service string, method *ast.Field) (
+	rpc *proto.RPC, err error) {
+	ft, ok := method.Type.(*ast.FuncType)
+	if !ok || len(method.Names) == 0 {
+		err = errors.Errorf("%s: embedded interface of %s is not supported",
+			t.position(pkg, method.Pos()), service)
+		return
+	}
+	name := method.Names[0].Name
+	unsupported := func() error {
+		return errors.Errorf("%s: unsupported signature of method %s.%s, want %s",
+			t.position(pkg, method.Pos()), service, name, rpcSignature)
+	}
+	params, results := fieldListTypes(ft.Params), fieldListTypes(ft.Results)
+	if len(params) < 2 || len(params) > 3 || !t.isContextType(pkg, params[0]) ||
+		len(results) < 1 || len(results) > 2 || !isErrorType(results[len(results)-1]) {
+		err = unsupported()
+		return
+	}
+
+	var request, response *rpcMessage
+	set := func(m **rpcMessage, pkg *ast.Package, expr ast.Expr, streams bool) bool {
+		if *m != nil {
+			return false
+		}
+		*m = &rpcMessage{pkg: pkg, expr: expr, streams: streams}
+		return true
+	}
+	ok = true
+	for i, param := range params[1:] {
+		switch typ := param.(type) {
+		case *ast.StarExpr:
+			ok = i == 0 && set(&request, pkg, typ, false)
+		case *ast.ChanType:
+			if i == 0 {
+				ok = set(&request, pkg, typ.Value, true)
+			} else {
+				ok = set(&response, pkg, typ.Value, true)
+			}
+		default:
+			spkg, recv, send, isStream, e := t.streamInterface(pkg, param)
+			if e != nil {
+				err = errors.Wrapf(e, "%s: failed to parse method %s.%s",
+					t.position(pkg, method.Pos()), service, name)
+				return
+			}
+			ok = isStream && (recv == nil || set(&request, spkg, recv, true)) &&
+				(send == nil || set(&response, spkg, send, true))
+		}
+		if !ok {
+			break
+		}
+	}
+	if ok && len(results) == 2 {
+		if ct, isChan := results[0].(*ast.ChanType); isChan {
+			ok = set(&response, pkg, ct.Value, true)
+		} else {
+			ok = set(&response, pkg, results[0], false)
+		}
+	}
+	if !ok || request == nil || response == nil {
+		err = unsupported()
+		return
+	}
+
+	rpc = &proto.RPC{Name: name}
+	rpc.Comment = t.protoFieldComment(method.Doc)
+	rpc.InlineComment = protoInlineComment(method.Comment)
+	if isDeprecated(method.Doc) {
+		rpc.Elements = append(rpc.Elements, deprecatedOption(false))
+	}
+	rpc.StreamsRequest, rpc.StreamsReturns = request.streams, response.streams
+	for _, each := range []struct {
+		message *rpcMessage
+		typeStr *string
+	}{{request, &rpc.RequestType}, {response, &rpc.ReturnsType}} {
+		typeStr, ok, e := t.parseRPCMessage(each.message.pkg, each.message.expr)
+		if e != nil {
+			err = errors.Wrapf(e, "%s: failed to parse method %s.%s",
+				t.position(pkg, method.Pos()), service, name)
+			return
+		}
+		if !ok {
+			err = unsupported()
+			return
+		}
+		*each.typeStr = typeStr
+	}
+	option, err := t.httpRuleOption(rpc, method.Doc)
+	if err != nil {
+		err = errors.Wrapf(err, "%s: invalid method %s.%s", t.position(pkg, method.Pos()),
+			service, name)
+		return
+	}
+	if option != nil {
+		rpc.Elements = append(rpc.Elements, option)
+	}
+	return
+}
+
+// streamInterface resolves stream interface, recv and send are message types of its
+// Recv() (*Request, error) and Send(*Response) error methods, which belong to spkg
+func (t *Parser) streamInterface(pkg *ast.Package, expr ast.Expr) (
+	spkg *ast.Package, recv, send ast.Expr, ok bool, err error) {
+	var typeStr string
+	switch typ := expr.(type) {
+	case *ast.Ident:
+		typeStr = typ.Name
+	case *ast.SelectorExpr:
+		typeStr = selectorExprTypeStr(typ)
+	default:
+		return
+	}
+	spkg, ts, err := t.lookupType(pkg, typeStr)
+	if err != nil || ts == nil {
+		err = errors.WithStack(err)
+		return
+	}
+	it, ok := ts.Type.(*ast.InterfaceType)
+	if !ok {
+		return
+	}
+	for _, method := range it.Methods.List {
+		ft, isFunc := method.Type.(*ast.FuncType)
+		if !isFunc || len(method.Names) == 0 {
+			continue
+		}
+		params, results := fieldListTypes(ft.Params), fieldListTypes(ft.Results)
+		switch method.Names[0].Name {
+		case "Recv":
+			if len(params) == 0 && len(results) == 2 && isErrorType(results[1]) {
+				recv = results[0]
+			}
+		case "Send":
+			if len(params) == 1 && len(results) == 1 && isErrorType(results[0]) {
+				send = params[0]
+			}
+		}
+	}
+	ok = recv != nil || send != nil
+	return
+}
+
+// parseRPCMessage parses pointer to named struct into the request or response message of
+// rpc, ok is false if expr is not a pointer to named struct
+func (t *Parser) parseRPCMessage(pkg *ast.Package, expr ast.Expr) (
+	typeStr string, ok bool, err error) {
+	star, ok := expr.(*ast.StarExpr)
+	if !ok {
+		return
+	}
+	tpkg, texpr, err := t.underlyingType(pkg, star.X)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	st, ok := identStructType(texpr)
+	if !ok {
+		return
+	}
+	title := t.typeTitle(tpkg, texpr.(*ast.Ident).Name)
+	err = t.parseMessage(tpkg, st, title)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	typeStr = t.protoTypeName(tpkg, title)
+	return
+}
+
+// sortedServices returns services sorted by names
+func sortedServices(services map[string]*proto.Service) (sorted []proto.Visitee) {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sorted = append(sorted, services[name])
+	}
+	return
+}
+
+// serviceRPCs returns rpcs of service
+func serviceRPCs(service *proto.Service) (rpcs []*proto.RPC) {
+	for _, each := range service.Elements {
+		if rpc, ok := each.(*proto.RPC); ok {
+			rpcs = append(rpcs, rpc)
+		}
+	}
+	return
+}
+
+// serviceOptionNames returns names of options of service and its rpcs
+func serviceOptionNames(service *proto.Service) (names []string) {
+	for _, each := range service.Elements {
+		switch v := each.(type) {
+		case *proto.Option:
+			names = append(names, v.Name)
+		case *proto.RPC:
+			for _, e := range v.Elements {
+				if opt, ok := e.(*proto.Option); ok {
+					names = append(names, opt.Name)
+				}
+			}
+		}
+	}
+	return
+}
+
+// localService returns a copy of service whose name and message types of the same proto
+// package are not qualified
+func localService(service *proto.Service, protoPkg string) *proto.Service {
+	s := *service
+	s.Name = localTypeName(service.Name, protoPkg)
+	s.Elements = make([]proto.Visitee, 0, len(service.Elements))
+	for _, each := range service.Elements {
+		if rpc, ok := each.(*proto.RPC); ok {
+			r := *rpc
+			r.RequestType = localTypeName(rpc.RequestType, protoPkg)
+			r.ReturnsType = localTypeName(rpc.ReturnsType, protoPkg)
+			each = &r
+		}
+		s.Elements = append(s.Elements, each)
+	}
+	return &s
+}
+
+// position returns the file:line:column of pos in package
+func (t *Parser) position(pkg *ast.Package, pos token.Pos) string {
+	if position := t.fset.Position(pos); position.IsValid() {
+		return position.String()
+	}
+	return pkg.Name
+}
+
+// fieldListTypes returns types of params or results, a type is repeated for each name
+func fieldListTypes(list *ast.FieldList) (types []ast.Expr) {
+	if list == nil {
+		return
+	}
+	for _, field := range list.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, field.Type)
+		}
+	}
+	return
+}
+
+// isContextType checks whether expr is context.Context, the package is resolved by import path
+func (t *Parser) isContextType(pkg *ast.Package, expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "Context" && t.selectorImportPath(pkg, sel) == "context"
+}
+
+func isErrorType(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "error" && ident.Obj == nil
+}
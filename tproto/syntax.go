@@ -0,0 +1,91 @@
+package tproto
+
+import (
+	"github.com/emicklei/proto"
+)
+
+// Syntax defines the syntax of rendered proto files
+type Syntax string
+
+const (
+	// SyntaxProto3 renders proto3 files, scalar fields with presence are optional
+	SyntaxProto3 Syntax = "proto3"
+	// SyntaxProto2 renders proto2 files, singular fields tagged with required:"true" are
+	// required and the others are optional
+	SyntaxProto2 Syntax = "proto2"
+	// SyntaxEditions renders files of Edition, fields have implicit presence by the file
+	// feature as proto3 ones, fields with presence and required fields override the feature
+	SyntaxEditions Syntax = "editions"
+)
+
+// Edition defines the edition of files rendered by SyntaxEditions
+const Edition = "2023"
+
+// RequiredTagName defines the struct tag of required fields, which is read by tspec too
+const RequiredTagName = "required"
+
+// fieldPresenceFeature defines the feature of field presence in editions
+const fieldPresenceFeature = "features.field_presence"
+
+// syntax returns the syntax of rendered proto files, defaults to SyntaxProto3
+func (t *Parser) syntax() Syntax {
+	if t.opts.Syntax == "" {
+		return SyntaxProto3
+	}
+	return t.opts.Syntax
+}
+
+// syntaxElement returns the syntax statement of proto file, or the edition statement of
+// SyntaxEditions
+func (t *Parser) syntaxElement() proto.Visitee {
+	if t.syntax() == SyntaxEditions {
+		return &editionStatement{Edition: Edition}
+	}
+	return &proto.Syntax{Value: string(t.syntax())}
+}
+
+// syntaxFileOptions returns file options required by the syntax
+func (t *Parser) syntaxFileOptions() (options []proto.Visitee) {
+	if t.syntax() == SyntaxEditions {
+		options = append(options, &proto.Option{
+			Name:     fieldPresenceFeature,
+			Constant: proto.Literal{Source: "IMPLICIT"},
+		})
+	}
+	return
+}
+
+// setFieldLabel sets label and presence of singular proto field by ParserOptions.Syntax
+func (t *Parser) setFieldLabel(fieldProto proto.Visitee, required bool) {
+	f, ok := fieldProto.(*proto.NormalField)
+	if !ok || f.Repeated {
+		return
+	}
+	switch t.syntax() {
+	case SyntaxProto2:
+		f.Required, f.Optional = required, !required
+	case SyntaxEditions:
+		presence := ""
+		if required {
+			presence = "LEGACY_REQUIRED"
+		} else if f.Optional {
+			presence = "EXPLICIT"
+		}
+		f.Optional = false
+		if presence != "" {
+			f.Options = append(f.Options, &proto.Option{
+				Name:       fieldPresenceFeature,
+				Constant:   proto.Literal{Source: presence},
+				IsEmbedded: true,
+			})
+		}
+	}
+}
+
+// editionStatement defines the edition statement of proto file, which proto doesn't define
+type editionStatement struct {
+	Edition string
+}
+
+// Accept implements proto.Visitee, the statement is printed by formatProto
+func (e *editionStatement) Accept(v proto.Visitor) {}
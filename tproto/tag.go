@@ -0,0 +1,104 @@
+package tproto
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FieldTagName defines the struct tag controlling proto fields,
+// e.g. `tproto:"3,name=user_id,type=sint64"` or `tproto:"skip"`
+const FieldTagName = "tproto"
+
+// protoScalarEncodings groups proto scalar types sharing the same golang type,
+// the scalar encoding can be changed by the type option of FieldTagName within a group
+var protoScalarEncodings = map[string]string{
+	"int32": "int32", "sint32": "int32", "sfixed32": "int32",
+	"int64": "int64", "sint64": "int64", "sfixed64": "int64",
+	"uint32": "uint32", "fixed32": "uint32",
+	"uint64": "uint64", "fixed64": "uint64",
+}
+
+// fieldTag defines the parsed FieldTagName of a golang struct field
+type fieldTag struct {
+	number    int
+	name      string
+	protoType string
+	skip      bool
+}
+
+// parseFieldTagValue parses the value of FieldTagName
+func parseFieldTagValue(value string) (tag fieldTag, err error) {
+	if strings.TrimSpace(value) == "-" {
+		tag.skip = true
+		return
+	}
+	for i, opt := range strings.Split(value, ",") {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case opt == "":
+		case opt == "skip":
+			tag.skip = true
+		case strings.HasPrefix(opt, "name="):
+			tag.name = strings.TrimPrefix(opt, "name=")
+			if !isProtoIdent(tag.name) {
+				err = errors.Errorf("invalid name %q", tag.name)
+				return
+			}
+		case strings.HasPrefix(opt, "type="):
+			tag.protoType = strings.TrimPrefix(opt, "type=")
+			if !protoScalarTypes[tag.protoType] {
+				err = errors.Errorf("invalid type %q, want proto scalar type", tag.protoType)
+				return
+			}
+		case i == 0:
+			n, e := strconv.Atoi(opt)
+			if e != nil {
+				err = errors.Errorf("invalid field number %q", opt)
+				return
+			}
+			if n < 1 || n > maxFieldNumber {
+				err = errors.Errorf("field number %d out of range", n)
+				return
+			}
+			if n >= firstReservedFieldNumber && n <= lastReservedFieldNumber {
+				err = errors.Errorf("field number %d is reserved by protobuf", n)
+				return
+			}
+			tag.number = n
+		default:
+			err = errors.Errorf("unknown option %q", opt)
+			return
+		}
+	}
+	return
+}
+
+// overrideScalarType changes the scalar encoding of typeStr to protoType
+func overrideScalarType(typeStr, protoType string) (string, error) {
+	if typeStr == protoType {
+		return typeStr, nil
+	}
+	encoding, ok := protoScalarEncodings[typeStr]
+	if !ok || encoding != protoScalarEncodings[protoType] {
+		return "", errors.Errorf("type %s is incompatible with %s", protoType, typeStr)
+	}
+	return protoType, nil
+}
+
+// isProtoIdent checks whether s is a valid proto identifier
+func isProtoIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
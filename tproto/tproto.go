@@ -2,37 +2,201 @@ package tproto
 
 import (
 	"bytes"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
 	"os"
+	"path"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
 
 	"github.com/emicklei/proto"
-	"github.com/emicklei/proto-contrib/pkg/protofmt"
-	"github.com/go-openapi/spec"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/wy-z/tspec/tspec"
 )
 
-var jsonProtoTypeMap = map[string]string{
-	"integer":          "int64",
-	"integer:int32":    "int32",
-	"integer:int64":    "int64",
-	"number":           "double",
-	"number:float":     "float",
-	"number:double":    "double",
-	"string":           "string",
-	"string:byte":      "bytes",
-	"string:binary":    "bytes",
-	"boolean":          "bool",
-	"string:date":      "string",
-	"string:date-time": "string",
+var goProtoTypeMap = map[string]string{
+	"bool": "bool",
+	"uint": "uint64", "uint8": "uint32", "uint16": "uint32",
+	"uint32": "uint32", "uint64": "uint64",
+	"int": "int64", "int8": "int32", "int16": "int32",
+	"int32": "int32", "int64": "int64",
+	"uintptr": "uint64",
+	"float32": "float", "float64": "double",
+	"string":    "string",
+	"complex64": "float", "complex128": "double",
+	"byte": "bytes", "rune": "bytes",
 }
 
+// goProtoSignedTypeMap overrides goProtoTypeMap when UnsignedAsSigned is set
+var goProtoSignedTypeMap = map[string]string{
+	"uint": "int64", "uint8": "int32", "uint16": "int32",
+	"uint32": "int32", "uint64": "int64",
+	"uintptr": "int64",
+}
+
+// goWellKnownTypeMap maps golang types qualified by import paths to proto well-known types
+var goWellKnownTypeMap = map[string]string{
+	"time.Time":     "google.protobuf.Timestamp",
+	"time.Duration": "google.protobuf.Duration",
+}
+
+// protoScalarTypes defines all proto scalar types
+var protoScalarTypes = map[string]bool{
+	"double": true, "float": true,
+	"int32": true, "int64": true, "uint32": true, "uint64": true,
+	"sint32": true, "sint64": true, "fixed32": true, "fixed64": true,
+	"sfixed32": true, "sfixed64": true,
+	"bool": true, "string": true, "bytes": true,
+}
+
+// protoWrapperTypeMap maps proto scalar types to google.protobuf wrapper types
+var protoWrapperTypeMap = map[string]string{
+	"double": "google.protobuf.DoubleValue", "float": "google.protobuf.FloatValue",
+	"int64": "google.protobuf.Int64Value", "uint64": "google.protobuf.UInt64Value",
+	"int32": "google.protobuf.Int32Value", "uint32": "google.protobuf.UInt32Value",
+	"bool": "google.protobuf.BoolValue", "string": "google.protobuf.StringValue",
+	"bytes": "google.protobuf.BytesValue",
+}
+
+// Dynamic golang types which can be mapped to proto types by ParserOptions.DynamicTypes
+const (
+	DynamicInterface = "interface{}"
+	DynamicMap       = "map[string]interface{}"
+	DynamicRawJSON   = "json.RawMessage"
+)
+
+// rawJSONType defines json.RawMessage qualified by its import path
+const rawJSONType = "encoding/json.RawMessage"
+
+// DefaultDynamicTypes returns the proto types which dynamic golang types are mapped to by
+// default, the returned map is owned by the caller
+func DefaultDynamicTypes() map[string]string {
+	return map[string]string{
+		DynamicInterface: "google.protobuf.Value",
+		DynamicMap:       "google.protobuf.Struct",
+		DynamicRawJSON:   "bytes",
+	}
+}
+
+// protoMapKeyTypes defines proto types which can be used as map keys
+var protoMapKeyTypes = map[string]bool{
+	"int32": true, "int64": true, "uint32": true, "uint64": true,
+	"sint32": true, "sint64": true, "fixed32": true, "fixed64": true,
+	"sfixed32": true, "sfixed64": true,
+	"bool": true, "string": true,
+}
+
+// mapEntryFieldNames defines field names of map entry messages
+var mapEntryFieldNames = []string{"key", "value"}
+
+// wellKnownTypeImports maps proto well-known types to their proto files
+var wellKnownTypeImports = map[string]string{
+	"google.protobuf.Any":         "google/protobuf/any.proto",
+	"google.protobuf.Value":       "google/protobuf/struct.proto",
+	"google.protobuf.Struct":      "google/protobuf/struct.proto",
+	"google.protobuf.ListValue":   "google/protobuf/struct.proto",
+	"google.protobuf.Timestamp":   "google/protobuf/timestamp.proto",
+	"google.protobuf.Duration":    "google/protobuf/duration.proto",
+	"google.protobuf.DoubleValue": "google/protobuf/wrappers.proto",
+	"google.protobuf.FloatValue":  "google/protobuf/wrappers.proto",
+	"google.protobuf.Int64Value":  "google/protobuf/wrappers.proto",
+	"google.protobuf.UInt64Value": "google/protobuf/wrappers.proto",
+	"google.protobuf.Int32Value":  "google/protobuf/wrappers.proto",
+	"google.protobuf.UInt32Value": "google/protobuf/wrappers.proto",
+	"google.protobuf.BoolValue":   "google/protobuf/wrappers.proto",
+	"google.protobuf.StringValue": "google/protobuf/wrappers.proto",
+	"google.protobuf.BytesValue":  "google/protobuf/wrappers.proto",
+}
+
+// customOptionImports maps packages of custom options to their proto files
+var customOptionImports = map[string]string{
+	"buf.validate": "buf/validate/validate.proto",
+	"google.api":   "google/api/annotations.proto",
+}
+
+// customOptionImport returns the proto file of custom option, e.g. (buf.validate.field).required
+func customOptionImport(name string) (path string, ok bool) {
+	if !strings.HasPrefix(name, "(") || !strings.Contains(name, ")") {
+		return
+	}
+	ext := name[1:strings.Index(name, ")")]
+	if i := strings.LastIndex(ext, "."); i >= 0 {
+		path, ok = customOptionImports[ext[:i]]
+	}
+	return
+}
+
+// EmbedMode defines how embedded structs are rendered
+type EmbedMode string
+
+const (
+	// EmbedFlatten copies fields of embedded structs into the parent, as encoding/json does
+	EmbedFlatten EmbedMode = "flatten"
+	// EmbedCompose renders embedded structs as message fields named after the embedded types
+	EmbedCompose EmbedMode = "compose"
+)
+
+// PresenceMode defines how scalar and enum fields with presence are rendered
+type PresenceMode string
+
+const (
+	// PresenceNone renders fields with presence as plain scalar fields
+	PresenceNone PresenceMode = ""
+	// PresenceOptional renders fields with presence as proto3 optional fields
+	PresenceOptional PresenceMode = "optional"
+	// PresenceWrapper renders fields with presence as google.protobuf wrapper types, enum
+	// fields don't have wrapper types and are rendered as plain fields
+	PresenceWrapper PresenceMode = "wrapper"
+)
+
 // ParserOptions defines tproto parser options
 type ParserOptions struct {
 	tspec.ParserOptions
+	// UnsignedAsSigned maps unsigned integers to signed proto scalars, as older tproto did
+	UnsignedAsSigned bool
+	// TimeWellKnownTypes maps time.Time and time.Duration to google.protobuf.Timestamp and
+	// google.protobuf.Duration
+	TimeWellKnownTypes bool
+	// Presence defines how pointer scalar fields are rendered
+	Presence PresenceMode
+	// OmitEmptyPresence treats scalar fields tagged with json omitempty as fields with presence
+	OmitEmptyPresence bool
+	// Embedding defines how embedded structs are rendered, defaults to EmbedFlatten
+	Embedding EmbedMode
+	// Namespace defines how types declared outside the parsed package are named
+	Namespace NamespaceMode
+	// PackageFiles qualifies messages and enums by proto packages derived from golang import
+	// paths, so that each golang package is rendered into its own file by RenderProtoFiles.
+	// Namespace is ignored since types of different packages don't share a file
+	PackageFiles bool
+	// Renames maps qualified golang types, e.g. github.com/wy-z/tproto/samples.BasicTypes,
+	// to proto names, it overrides Namespace
+	Renames map[string]string
+	// DynamicTypes maps dynamic golang types (DynamicInterface, DynamicMap and DynamicRawJSON)
+	// to proto types, unmapped dynamic types are ignored. Nil maps them by DefaultDynamicTypes
+	DynamicTypes map[string]string
+	// SnakeCaseFields renders snake_case field names, the json_name option is set if the
+	// field name differs from the JSON name, as it is for fields renamed by FieldTagName
+	SnakeCaseFields bool
+	// CamelCaseMessages renders CamelCase message and enum names without underscores
+	CamelCaseMessages bool
+	// UpperSnakeEnumValues renders UPPER_SNAKE enum values prefixed by the enum name
+	UpperSnakeEnumValues bool
+	// ValidateRules translates validate tags of go-playground validator into protovalidate
+	// options, rules which can't be translated are reported by Parser.ValidateWarnings
+	ValidateRules bool
+	// FileOptions defines options of rendered proto files
+	FileOptions FileOptions
+	// Syntax defines the syntax of rendered proto files, defaults to SyntaxProto3
+	Syntax Syntax
+	// Decorator is the decorator of parsed types, e.g. @service, which is stripped from doc
+	// comments as OneofDecorator and HTTPDecorator directives are
+	Decorator string
 }
 
 const tspecRefPrefix = "#/"
@@ -43,19 +207,55 @@ var DefaultParserOptions = ParserOptions{
 		IgnoreJSONTag: false,
 		RefPrefix:     tspecRefPrefix,
 	},
+	Embedding: EmbedFlatten,
+	FileOptions: FileOptions{
+		GoPackageSuffix: DefaultGoPackageSuffix,
+	},
 }
 
 // Parser defines tproto parser
 type Parser struct {
 	messages map[string]*proto.Message
+	enums    map[string]*proto.Enum
+	services map[string]*proto.Service
 	opts     ParserOptions
 	lock     sync.Mutex
+
+	schemaLock *Lock
+	goTypes    map[string]string
+	identities map[string]string
+	namespaces map[string]string
+	owners     map[string]*ast.Package
+	fieldTags  map[string]map[int]int
+	enumValues map[string]map[string]int
+	valueNames map[string]string
+
+	fieldTagSkips    []string
+	validateWarnings []*ValidateWarning
+
+	loader   *tspec.Parser
+	fset     *token.FileSet
+	dirPkgs  map[string]*ast.Package
+	root     *ast.Package
+	pkgPaths map[*ast.Package]string
+	parsed   map[string]bool
+	consts   map[*ast.Package]map[string][]*goConst
+	methods  map[*ast.Package]map[string]map[string]bool
 }
 
 // NewParser returns inited tproto parser
 func NewParser() (parser *Parser) {
 	parser = new(Parser)
 	parser.messages = make(map[string]*proto.Message)
+	parser.enums = make(map[string]*proto.Enum)
+	parser.services = make(map[string]*proto.Service)
+	parser.goTypes = make(map[string]string)
+	parser.identities = make(map[string]string)
+	parser.namespaces = make(map[string]string)
+	parser.owners = make(map[string]*ast.Package)
+	parser.fieldTags = make(map[string]map[int]int)
+	parser.enumValues = make(map[string]map[string]int)
+	parser.valueNames = make(map[string]string)
 	parser.opts = DefaultParserOptions
 	return
 }
@@ -79,7 +279,13 @@ func (t *Parser) SetMessages(messages map[string]*proto.Message) {
 	return
 }
 
-// LoadProtoFile loads messages from proto file
+// Enums returns all enums
+func (t *Parser) Enums() map[string]*proto.Enum {
+	return t.enums
+}
+
+// LoadProtoFile loads messages and enums from proto file, parsed messages keep field numbers
+// of the loaded ones and string enums keep their value numbers
 func (t *Parser) LoadProtoFile(path string) (err error) {
 	p, err := ParseProtoFile(path)
 	if err != nil {
@@ -87,169 +293,916 @@ func (t *Parser) LoadProtoFile(path string) (err error) {
 		return
 	}
 	for _, each := range p.Elements {
-		if msg, ok := each.(*proto.Message); ok {
-			t.messages[msg.Name] = msg
+		switch v := each.(type) {
+		case *proto.Message:
+			t.messages[v.Name] = v
+		case *proto.Enum:
+			t.enums[v.Name] = v
 		}
 	}
 	return
 }
 
-// Reset cleans all messages
+// Reset cleans all messages, enums, services, loaded packages, the loaded lock and field
+// numbers to write
 func (t *Parser) Reset() {
 	t.messages = make(map[string]*proto.Message)
+	t.enums = make(map[string]*proto.Enum)
+	t.services = make(map[string]*proto.Service)
+	t.schemaLock = nil
+	t.goTypes = make(map[string]string)
+	t.identities = make(map[string]string)
+	t.namespaces = make(map[string]string)
+	t.owners = make(map[string]*ast.Package)
+	t.fieldTags = make(map[string]map[int]int)
+	t.enumValues = make(map[string]map[string]int)
+	t.valueNames = make(map[string]string)
+	t.fieldTagSkips = nil
+	t.validateWarnings = nil
+	t.loader = nil
 	return
 }
 
-// ProtoSyntax defines proto synatax
-const ProtoSyntax = "proto3"
+// ProtoSyntax defines the default proto syntax, see ParserOptions.Syntax
+const ProtoSyntax = string(SyntaxProto3)
 
-// RenderProto renders proto messages
+// RenderProto renders proto messages, enums and services
 func (t *Parser) RenderProto(protoPkg string) (buf *bytes.Buffer) {
 	p := new(proto.Proto)
-	p.Elements = append(p.Elements, &proto.Syntax{
-		Value: ProtoSyntax,
-	})
+	p.Elements = append(p.Elements, t.syntaxElement())
 	p.Elements = append(p.Elements, &proto.Package{
 		Name: protoPkg,
 	})
 
+	for _, path := range protoImports(t.messages, t.services) {
+		p.Elements = append(p.Elements, &proto.Import{
+			Filename: path,
+		})
+	}
+	p.Elements = append(p.Elements, t.fileOptions(t.root)...)
+
 	keys := make(sort.StringSlice, 0, 2)
+	for k := range t.enums {
+		keys = append(keys, k)
+	}
+	keys.Sort()
+	var elements []proto.Visitee
+	for _, k := range keys {
+		elements = append(elements, t.enums[k])
+	}
+	keys = make(sort.StringSlice, 0, 2)
 	for k := range t.messages {
 		keys = append(keys, k)
 	}
 	keys.Sort()
 	for _, k := range keys {
-		p.Elements = append(p.Elements, t.messages[k])
+		elements = append(elements, t.messages[k])
 	}
+	p.Elements = append(p.Elements, nestNamespaces(elements)...)
+	p.Elements = append(p.Elements, sortedServices(t.services)...)
+	buf = formatProto(p)
+	return
+}
 
-	buf = bytes.NewBuffer(nil)
-	protofmt.NewFormatter(buf, "  ").Format(p)
+// protoImports returns sorted proto files of well-known types and custom options imported
+// by messages and services
+func protoImports(messages map[string]*proto.Message, services map[string]*proto.Service) (
+	imports []string) {
+	pathSet := make(map[string]bool)
+	for _, service := range services {
+		for _, name := range serviceOptionNames(service) {
+			if path, ok := customOptionImport(name); ok {
+				pathSet[path] = true
+			}
+		}
+	}
+	for _, msg := range messages {
+		for _, typ := range messageFieldTypes(msg) {
+			if path, ok := wellKnownTypeImports[typ]; ok {
+				pathSet[path] = true
+			}
+		}
+		for _, name := range messageOptionNames(msg) {
+			if path, ok := customOptionImport(name); ok {
+				pathSet[path] = true
+			}
+		}
+	}
+	for path := range pathSet {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
 	return
 }
 
-func (t *Parser) parseDefinitionField(title string, field *spec.Schema, sequence int) (fieldProto proto.Visitee, err error) {
-	typeStr := schemaTypeStr(field)
-	var isMap, isArray, isRef bool
-	if typeStr == "object" && field.AdditionalProperties != nil {
-		field = field.AdditionalProperties.Schema
-		typeStr = schemaTypeStr(field)
-		isMap = true
-	} else if typeStr == "array" {
-		field = field.Items.Schema
-		typeStr = schemaTypeStr(field)
-		isArray = true
+// goField defines a golang struct field which will be parsed into a proto field
+type goField struct {
+	name      string
+	jsonName  string
+	pkg       *ast.Package
+	expr      ast.Expr
+	typeTitle string
+	presence  bool
+	// number and protoType are set by FieldTagName
+	number    int
+	protoType string
+	// owner is the struct declaring the field, depth is the embedding depth of owner
+	owner string
+	depth int
+	// field is the source field, description, validate and required are its tags
+	field       *ast.Field
+	description string
+	validate    string
+	required    bool
+}
+
+// structFields collects fields of struct, fields of embedded structs are flattened or
+// composed according to ParserOptions.Embedding
+func (t *Parser) structFields(pkg *ast.Package, st *ast.StructType, title string) (
+	fields map[string]*goField, err error) {
+	candidates := make(map[string][]*goField)
+	err = t.collectFields(pkg, st, title, 0, candidates, make(map[*ast.StructType]bool))
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	names := make(sort.StringSlice, 0, len(candidates))
+	for name := range candidates {
+		names = append(names, name)
 	}
-	if field.Ref.String() != "" {
-		isRef = true
+	names.Sort()
+	fields = make(map[string]*goField)
+	for _, name := range names {
+		// the shallowest field wins, like encoding/json
+		fs := candidates[name]
+		sort.SliceStable(fs, func(i, j int) bool {
+			return fs[i].depth < fs[j].depth
+		})
+		if len(fs) > 1 && fs[0].depth == fs[1].depth {
+			err = errors.Errorf("field name conflict in %s: %s is defined by both %s and %s",
+				title, name, fs[0].owner, fs[1].owner)
+			return
+		}
+		fields[name] = fs[0]
 	}
+	return
+}
 
-	f := new(proto.Field)
-	f.Name = title
-	f.Sequence = sequence
-	if typeStr == emptyType {
-		log.Warnf("ignored unsupported type %s", title)
+func (t *Parser) collectFields(pkg *ast.Package, st *ast.StructType, title string, depth int,
+	candidates map[string][]*goField, visiting map[*ast.StructType]bool) (err error) {
+	if st.Fields == nil || visiting[st] {
+		return
+	}
+	visiting[st] = true
+	defer delete(visiting, st)
+
+	for _, field := range st.Fields.List {
+		tags := parseFieldTag(field)
+		if !t.opts.IgnoreJSONTag && tags["json"] == "-" {
+			continue
+		}
+		tag, e := parseFieldTagValue(tags[FieldTagName])
+		if e != nil {
+			err = errors.Wrapf(e, "invalid %s tag of %s.%s", FieldTagName, title, fieldName(field))
+			return
+		}
+		if tag.skip {
+			continue
+		}
+		jName, omitEmpty := "", false
+		if !t.opts.IgnoreJSONTag && len(tags["json"]) > 0 {
+			jsonOpts := strings.Split(tags["json"], ",")
+			jName = strings.TrimSpace(jsonOpts[0])
+			for _, opt := range jsonOpts[1:] {
+				omitEmpty = omitEmpty || strings.TrimSpace(opt) == "omitempty"
+			}
+		}
+		_, isStar := field.Type.(*ast.StarExpr)
+		presence := isStar || (t.opts.OmitEmptyPresence && omitEmpty)
+
+		if len(field.Names) == 0 {
+			typeName := fieldName(field)
+			if jName == "" && tag.name == "" {
+				epkg, expr, e := t.underlyingType(pkg, starExprX(field.Type))
+				if e != nil {
+					err = errors.WithStack(e)
+					return
+				}
+				if est, ok := identStructType(expr); ok && t.opts.Embedding != EmbedCompose {
+					err = t.collectFields(epkg, est, typeName, depth+1, candidates, visiting)
+					if err != nil {
+						err = errors.WithStack(err)
+						return
+					}
+					continue
+				}
+				if !ast.IsExported(typeName) {
+					continue
+				}
+			}
+			if jName == "" {
+				jName = typeName
+			}
+			name := t.fieldTagName(tag, jName)
+			candidates[name] = append(candidates[name], &goField{name: name, jsonName: jName,
+				pkg: pkg, expr: field.Type, typeTitle: title + "_" + typeName, presence: presence,
+				number: tag.number, protoType: tag.protoType, owner: title, depth: depth, field: field,
+				description: tags["description"], validate: tags[ValidateTagName],
+				required: tags[RequiredTagName] == "true"})
+			continue
+		}
+
+		for _, ident := range field.Names {
+			if !ast.IsExported(ident.Name) {
+				continue
+			}
+			jsonName := ident.Name
+			if jName != "" {
+				jsonName = jName
+			}
+			name := t.fieldTagName(tag, jsonName)
+			candidates[name] = append(candidates[name], &goField{name: name, jsonName: jsonName,
+				pkg: pkg, expr: field.Type, typeTitle: title + "_" + ident.Name, presence: presence,
+				number: tag.number, protoType: tag.protoType, owner: title, depth: depth, field: field,
+				description: tags["description"], validate: tags[ValidateTagName],
+				required: tags[RequiredTagName] == "true"})
+		}
+	}
+	return
+}
+
+func (t *Parser) parseMessage(pkg *ast.Package, st *ast.StructType, title string) (err error) {
+	if t.parsed[t.identity(pkg, title)] {
+		return
+	}
+	t.parsed[t.identity(pkg, title)] = true
+
+	message := new(proto.Message)
+	message.Name = t.protoTypeName(pkg, title)
+	doc := typeDoc(pkg, t.structTypeSpec(pkg, st))
+	message.Comment = t.protoComment(doc)
+	if isDeprecated(doc) {
+		message.Elements = append(message.Elements, deprecatedOption(false))
+	}
+	err = t.registerType(pkg, title, message.Name)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	fields, err := t.structFields(pkg, st, title)
+	if err != nil {
+		err = errors.WithStack(err)
 		return
 	}
+	keys := make(sort.StringSlice, 0, 12)
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	keys.Sort()
+	fieldProtos := make([]proto.Visitee, len(keys))
+	taken := make(map[int]string)
+	fixed := make(map[string]bool)
+	for i, k := range keys {
+		fieldProtos[i], err = t.parseField(fields[k], fields[k].number)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse field %s.%s", title, k)
+			return
+		}
+		if fields[k].number == 0 {
+			continue
+		}
+		for _, f := range visiteeFields(fieldProtos[i]) {
+			if name, ok := taken[f.Sequence]; ok {
+				err = errors.Errorf("duplicate field number %d in %s: %s and %s",
+					f.Sequence, title, name, f.Name)
+				return
+			}
+			taken[f.Sequence] = f.Name
+			fixed[f.Name] = true
+		}
+	}
+	// fields without numbers are numbered in sequence, skipping the taken numbers
+	sequence := 0
+	next := func() int {
+		sequence++
+		for taken[sequence] != "" {
+			sequence++
+		}
+		return sequence
+	}
+	for i, k := range keys {
+		if fields[k].number == 0 {
+			if fieldProtos[i] == nil {
+				// ignored fields still consume a number
+				next()
+			}
+			for _, f := range visiteeFields(fieldProtos[i]) {
+				f.Sequence = next()
+			}
+		}
+		if fieldProtos[i] != nil {
+			message.Elements = append(message.Elements, fieldProtos[i])
+		}
+	}
+	previous := t.messages[message.Name]
+	if previous == nil {
+		previous = t.lockedMessage(message.Name)
+	}
+	err = stabilizeFieldNumbers(message, previous, fixed)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if goType := t.goTypeName(pkg, st); goType != "" {
+		t.goTypes[message.Name] = goType
+	}
+	for i, k := range keys {
+		t.recordFieldTag(fields[k], fieldProtos[i])
+	}
+	t.messages[message.Name] = message
+	return
+}
+
+func (t *Parser) parseField(field *goField, sequence int) (fieldProto proto.Visitee, err error) {
+	pkg, expr, err := t.underlyingType(field.pkg, starExprX(field.expr))
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		if ts, ok := t.sealedInterface(pkg, ident); ok {
+			fieldProto, err = t.parseOneof(pkg, ts, field.name, sequence)
+			if err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			t.setFieldValidation(fieldProto, field)
+			t.setFieldComments(fieldProto, field)
+			return
+		}
+	}
 
-	if protoType, ok := jsonProtoTypeMap[typeStr]; ok {
-		f.Type = protoType
-	} else if isRef {
-		f.Type = typeStr
-	} else {
-		err = errors.Errorf("unsupported type %s", typeStr)
+	ft, err := t.parseFieldType(pkg, expr, field.typeTitle)
+	if err != nil {
+		err = errors.WithStack(err)
 		return
 	}
+	if ft.typeStr == "" {
+		log.Warnf("ignored unsupported type %s", field.name)
+		return
+	}
+	if field.protoType != "" {
+		ft.typeStr, err = overrideScalarType(ft.typeStr, field.protoType)
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
+
+	var isOptional bool
+	_, isEnum := t.enums[ft.typeStr]
+	if field.presence && !ft.isMap && !ft.repeated && (protoScalarTypes[ft.typeStr] || isEnum) {
+		switch t.opts.Presence {
+		case PresenceOptional:
+			isOptional = true
+		case PresenceWrapper:
+			// enums don't have wrapper types
+			if isEnum {
+				break
+			}
+			if wrapperType, ok := protoWrapperTypeMap[ft.typeStr]; ok {
+				ft.typeStr = wrapperType
+			} else {
+				isOptional = true
+			}
+		}
+	}
+
+	fieldProto = ft.field(field.name, sequence)
+	if f, ok := fieldProto.(*proto.NormalField); ok {
+		f.Optional = isOptional
+	}
+	t.setFieldLabel(fieldProto, field.required)
+	if field.name != field.jsonName {
+		// keep the JSON mapping compatible with encoding/json, whether the field is renamed by
+		// naming options or FieldTagName
+		f := visiteeFields(fieldProto)[0]
+		f.Options = append(f.Options, &proto.Option{
+			Name:     "json_name",
+			Constant: proto.Literal{Source: field.jsonName, IsString: true},
+		})
+	}
+	t.setFieldValidation(fieldProto, field)
+	t.setFieldComments(fieldProto, field)
+	return
+}
 
-	if isMap {
-		fp := &proto.MapField{
+// fieldType defines the proto type of a golang field
+type fieldType struct {
+	typeStr  string
+	keyType  string
+	repeated bool
+	isMap    bool
+}
+
+func (ft fieldType) field(name string, sequence int) proto.Visitee {
+	f := new(proto.Field)
+	f.Name = name
+	f.Sequence = sequence
+	f.Type = ft.typeStr
+	if ft.isMap {
+		return &proto.MapField{
 			Field:   f,
-			KeyType: "string",
+			KeyType: ft.keyType,
 		}
-		fieldProto = fp
-	} else {
-		fp := &proto.NormalField{
-			Field:    f,
-			Repeated: isArray,
+	}
+	return &proto.NormalField{
+		Field:    f,
+		Repeated: ft.repeated,
+	}
+}
+
+// parseFieldType parses golang field type expr, collections are parsed into repeated or
+// map fields, an empty type means the golang type is unsupported
+func (t *Parser) parseFieldType(pkg *ast.Package, expr ast.Expr, typeTitle string) (
+	ft fieldType, err error) {
+	pkg, expr, err = t.underlyingType(pkg, starExprX(expr))
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	switch typ := expr.(type) {
+	case *ast.ArrayType:
+		if isByteIdent(typ.Elt) {
+			ft.typeStr = "bytes"
+			break
+		}
+		ft.repeated = true
+		ft.typeStr, err = t.parseElemType(pkg, typ.Elt, typeTitle, typeTitle+"_Row")
+	case *ast.MapType:
+		if protoType := t.dynamicMapType(typ); protoType != "" {
+			ft.typeStr = protoType
+			break
+		}
+		ft.keyType, err = t.parseTypeRef(pkg, typ.Key, typeTitle+"_Key")
+		if err != nil || ft.keyType == "" {
+			break
 		}
-		fieldProto = fp
+		if protoMapKeyTypes[ft.keyType] {
+			ft.isMap = true
+			ft.typeStr, err = t.parseElemType(pkg, typ.Value, typeTitle, typeTitle+"_Value")
+			break
+		}
+		// proto can't express the key type, use repeated entries instead
+		ft.repeated = true
+		ft.typeStr, err = t.parseMapEntry(pkg, typ, ft.keyType, typeTitle)
+	default:
+		ft.typeStr, err = t.parseTypeRef(pkg, expr, typeTitle)
+	}
+	if err != nil {
+		err = errors.WithStack(err)
+		return
 	}
 	return
 }
 
-func (t *Parser) parseDefinition(def *spec.Schema) (message *proto.Message, err error) {
-	t.lock.Lock()
-	defer t.lock.Unlock()
-	if m, ok := t.messages[def.Title]; ok {
-		message = m
+// parseElemType parses the element type of repeated fields and map values,
+// nested collections are wrapped into messages named wrapperTitle
+func (t *Parser) parseElemType(pkg *ast.Package, expr ast.Expr, typeTitle, wrapperTitle string) (
+	typeStr string, err error) {
+	epkg, eexpr, err := t.underlyingType(pkg, starExprX(expr))
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	switch typ := eexpr.(type) {
+	case *ast.ArrayType:
+		if !isByteIdent(typ.Elt) {
+			return t.parseWrapper(epkg, typ, wrapperTitle)
+		}
+	case *ast.MapType:
+		if protoType := t.dynamicMapType(typ); protoType != "" {
+			typeStr = protoType
+			return
+		}
+		return t.parseWrapper(epkg, typ, wrapperTitle)
+	}
+	return t.parseTypeRef(pkg, expr, eltTitle(expr, typeTitle))
+}
+
+// field names of messages wrapping nested collections
+const (
+	wrapperRepeatedFieldName = "values"
+	wrapperMapFieldName      = "entries"
+)
+
+// parseWrapper parses nested collection into a message holding the repeated or map field
+func (t *Parser) parseWrapper(pkg *ast.Package, expr ast.Expr, title string) (typeStr string, err error) {
+	typeStr = t.protoTypeName(pkg, title)
+	if t.parsed[t.identity(pkg, title)] {
+		return
+	}
+	t.parsed[t.identity(pkg, title)] = true
+	err = t.registerType(pkg, title, typeStr)
+	if err != nil {
+		typeStr, err = "", errors.WithStack(err)
+		return
+	}
+
+	ft, err := t.parseFieldType(pkg, expr, title)
+	if err != nil || ft.typeStr == "" {
+		typeStr, err = "", errors.WithStack(err)
 		return
 	}
+	name := wrapperRepeatedFieldName
+	if ft.isMap {
+		name = wrapperMapFieldName
+	}
+	message := new(proto.Message)
+	message.Name = typeStr
+	message.Elements = append(message.Elements, ft.field(name, 1))
+	t.messages[typeStr] = message
+	return
+}
 
-	message = new(proto.Message)
-	message.Name = def.Title
-	typeStr := schemaTypeStr(def)
-	switch typeStr {
-	case "object":
-		fields := schemaAllProperties(def)
-		keys := make(sort.StringSlice, 0, 12)
-		for k := range fields {
-			keys = append(keys, k)
+// parseMapEntry parses golang map into an entry message with key and value fields,
+// an empty type means the value type is unsupported
+func (t *Parser) parseMapEntry(pkg *ast.Package, mt *ast.MapType, keyType, typeTitle string) (
+	typeStr string, err error) {
+	valueType, err := t.parseElemType(pkg, mt.Value, typeTitle, typeTitle+"_Value")
+	if err != nil || valueType == "" {
+		err = errors.WithStack(err)
+		return
+	}
+	title := typeTitle + "_Entry"
+	typeStr = t.protoTypeName(pkg, title)
+	if !t.parsed[t.identity(pkg, title)] {
+		t.parsed[t.identity(pkg, title)] = true
+		err = t.registerType(pkg, title, typeStr)
+		if err != nil {
+			typeStr, err = "", errors.WithStack(err)
+			return
+		}
+		message := new(proto.Message)
+		message.Name = typeStr
+		for i, typ := range []string{keyType, valueType} {
+			f := new(proto.Field)
+			f.Name = mapEntryFieldNames[i]
+			f.Type = typ
+			f.Sequence = i + 1
+			field := &proto.NormalField{Field: f}
+			t.setFieldLabel(field, false)
+			message.Elements = append(message.Elements, field)
 		}
-		keys.Sort()
-		for i, k := range keys {
-			f, e := t.parseDefinitionField(k, fields[k], i+1)
+		t.messages[typeStr] = message
+	}
+	return
+}
+
+// dynamicMapType returns the proto type of map[string]interface{}, empty if not mapped
+func (t *Parser) dynamicMapType(mt *ast.MapType) string {
+	if ident, ok := mt.Key.(*ast.Ident); !ok || ident.Name != "string" || !isEmptyInterface(mt.Value) {
+		return ""
+	}
+	return t.dynamicType(DynamicMap)
+}
+
+// dynamicType returns the proto type which dynamic golang type is mapped to, empty if not
+// mapped
+func (t *Parser) dynamicType(goType string) string {
+	if t.opts.DynamicTypes == nil {
+		return DefaultDynamicTypes()[goType]
+	}
+	return t.opts.DynamicTypes[goType]
+}
+
+// parseTypeRef parses golang type expr and returns related proto type,
+// an empty type means the golang type is unsupported and should be ignored
+func (t *Parser) parseTypeRef(pkg *ast.Package, expr ast.Expr, typeTitle string) (
+	typeStr string, err error) {
+	pkg, expr, err = t.underlyingType(pkg, starExprX(expr))
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	switch typ := expr.(type) {
+	case *ast.Ident:
+		if typ.Obj != nil {
+			ts, e := objDeclTypeSpec(typ.Obj)
 			if e != nil {
 				err = errors.WithStack(e)
 				return
 			}
-			if f != nil {
-				message.Elements = append(message.Elements, f)
+			title := t.typeTitle(pkg, typ.Name)
+			switch typeSpecTyp := starExprX(ts.Type).(type) {
+			case *ast.StructType:
+				err = t.parseMessage(pkg, typeSpecTyp, title)
+			case *ast.InterfaceType:
+				err = t.parseOneofMessage(pkg, ts, title)
+			default:
+				err = t.parseEnum(pkg, ts, title)
 			}
+			if err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			typeStr = t.protoTypeName(pkg, title)
+			return
+		}
+		if typ.Name == "any" {
+			typeStr = t.dynamicType(DynamicInterface)
+			return
+		}
+		protoType, ok := goProtoTypeMap[typ.Name]
+		if !ok {
+			err = errors.Errorf("unsupported type %s", typ.Name)
+			return
+		}
+		if t.opts.UnsignedAsSigned {
+			if signedType, ok := goProtoSignedTypeMap[typ.Name]; ok {
+				protoType = signedType
+			}
+		}
+		typeStr = protoType
+	case *ast.SelectorExpr:
+		typeStr, _ = t.selectorProtoType(t.selectorImportPath(pkg, typ) + "." + typ.Sel.Name)
+	case *ast.StructType:
+		err = t.parseMessage(pkg, typ, typeTitle)
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+		typeStr = t.protoTypeName(pkg, typeTitle)
+	case *ast.InterfaceType:
+		if isEmptyInterface(typ) {
+			typeStr = t.dynamicType(DynamicInterface)
 		}
+	case *ast.ArrayType:
+		if !isByteIdent(typ.Elt) {
+			err = errors.Errorf("unsupported type %T", typ)
+			return
+		}
+		typeStr = "bytes"
 	default:
-		err = errors.Errorf("unsupported type %s", typeStr)
+		err = errors.Errorf("unsupported type %T", typ)
+	}
+	return
+}
+
+// underlyingType resolves named types until a struct type, an enum type, a sealed interface,
+// a basic type or an unnamed type, returned ident (named type) has obj setted
+func (t *Parser) underlyingType(pkg *ast.Package, expr ast.Expr) (
+	tpkg *ast.Package, texpr ast.Expr, err error) {
+	tpkg, texpr = pkg, expr
+	for {
+		var typeStr string
+		var isWellKnown bool
+		lpkg := tpkg
+		switch typ := starExprX(texpr).(type) {
+		case *ast.Ident:
+			typeStr = typ.Name
+		case *ast.SelectorExpr:
+			pkgPath := t.selectorImportPath(tpkg, typ)
+			qualified := pkgPath + "." + typ.Sel.Name
+			if _, ok := t.selectorProtoType(qualified); ok {
+				texpr = typ
+				return
+			}
+			_, isWellKnown = goWellKnownTypeMap[qualified]
+			if pkgPath == "" {
+				err = errors.Errorf("%s not found", selectorExprTypeStr(typ))
+				return
+			}
+			lpkg, err = t.importPkg(pkgPath)
+			if err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			t.pkgPaths[lpkg] = pkgPath
+			typeStr = typ.Sel.Name
+		default:
+			return
+		}
+		p, ts, e := t.lookupType(lpkg, typeStr)
+		if e != nil {
+			err = errors.WithStack(e)
+			return
+		}
+		if ts == nil {
+			// basic type
+			texpr = starExprX(texpr)
+			return
+		}
+		tpkg = p
+		_, isStruct := starExprX(ts.Type).(*ast.StructType)
+		if isStruct || (!isWellKnown && t.isEnumType(p, ts)) || t.isSealedInterface(p, ts) {
+			texpr = ts.Name
+			if ts.Name.Obj == nil {
+				ts.Name.Obj = ast.NewObj(ast.Typ, ts.Name.Name)
+				ts.Name.Obj.Decl = ts
+			}
+			return
+		}
+		texpr = ts.Type
+	}
+}
+
+// selectorProtoType returns the proto type which golang type qualified by import path, e.g.
+// time.Time or encoding/json.RawMessage, maps to directly
+func (t *Parser) selectorProtoType(typeStr string) (protoType string, ok bool) {
+	if t.opts.TimeWellKnownTypes {
+		if protoType, ok = goWellKnownTypeMap[typeStr]; ok {
+			return
+		}
+	}
+	if typeStr == "time.Time" {
+		protoType, ok = "string", true
+	}
+	if typeStr == rawJSONType && t.dynamicType(DynamicRawJSON) != "" {
+		protoType, ok = t.dynamicType(DynamicRawJSON), true
+	}
+	return
+}
+
+// selectorImportPath returns the import path of the package of selector type, which is
+// resolved by the imports of the file declaring expr, so that aliased imports and packages
+// sharing names are told apart. It returns an empty string if the package is not imported
+func (t *Parser) selectorImportPath(pkg *ast.Package, expr *ast.SelectorExpr) string {
+	x, ok := expr.X.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	f := pkg.Files[t.fset.Position(expr.Pos()).Filename]
+	if f == nil {
+		return ""
+	}
+	var unmatched []string
+	for _, ispec := range f.Imports {
+		pkgPath := strings.Trim(ispec.Path.Value, "\"")
+		switch {
+		case ispec.Name != nil:
+			if ispec.Name.Name == x.Name {
+				return pkgPath
+			}
+		case path.Base(pkgPath) == x.Name:
+			return pkgPath
+		default:
+			unmatched = append(unmatched, pkgPath)
+		}
+	}
+	// the package name may differ from the last element of import path
+	for _, pkgPath := range unmatched {
+		if p, e := t.importPkg(pkgPath); e == nil && p.Name == x.Name {
+			return pkgPath
+		}
+	}
+	return ""
+}
+
+// lookupType finds type spec by type str ("Type" or "pkg.Type"),
+// a nil type spec means typeStr is not a named type of the package
+func (t *Parser) lookupType(pkg *ast.Package, typeStr string) (
+	tpkg *ast.Package, ts *ast.TypeSpec, err error) {
+	strs := strings.Split(typeStr, ".")
+	if len(strs) == 1 {
+		objs, e := t.loader.ParsePkg(pkg)
+		if e != nil {
+			err = errors.WithStack(e)
+			return
+		}
+		obj, ok := objs[typeStr]
+		if !ok {
+			return
+		}
+		tpkg = pkg
+		ts, err = objDeclTypeSpec(obj)
 		return
 	}
-	t.messages[def.Title] = message
+
+	pkgName, typeTitle := strs[0], strs[1]
+	for _, file := range pkg.Files {
+		for _, ispec := range file.Imports {
+			if ispec.Name != nil && ispec.Name.Name != pkgName {
+				continue
+			}
+			pkgPath := strings.Trim(ispec.Path.Value, "\"")
+			p, e := t.importPkg(pkgPath)
+			if e != nil || (ispec.Name == nil && p.Name != pkgName) {
+				continue
+			}
+			t.pkgPaths[p] = pkgPath
+			tpkg, ts, err = t.lookupType(p, typeTitle)
+			if err != nil || ts != nil {
+				return
+			}
+		}
+	}
+	err = errors.Errorf("%s not found", typeStr)
 	return
 }
 
 // Parse parses golang type expr
 func (t *Parser) Parse(pkgPath, typeExpr string) (message *proto.Message, err error) {
-	def, defs, err := t.parseTypeExpr(pkgPath, typeExpr)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	pkg, err := t.load(pkgPath)
 	if err != nil {
 		err = errors.WithStack(err)
 		return
 	}
-	for _, d := range defs {
-		delete(t.messages, d.Title)
-		_, e := t.parseDefinition(&d)
-		if e != nil {
-			err = errors.WithStack(e)
-			return
-		}
+	tpkg, ts, err := t.lookupType(pkg, typeExpr)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if ts == nil {
+		err = errors.Errorf("%s not found in package %s", typeExpr, pkg.Name)
+		return
 	}
-	message = t.messages[def.Title]
+	st, ok := starExprX(ts.Type).(*ast.StructType)
+	if !ok {
+		err = errors.Errorf("unsupported type %s, want struct", typeExpr)
+		return
+	}
+	title := t.typeTitle(tpkg, ts.Name.Name)
+	err = t.parseMessage(tpkg, st, title)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	message = t.messages[t.protoTypeName(tpkg, title)]
 	return
 }
 
-func (t *Parser) parseTypeExpr(pkgPath, typeExpr string) (def *spec.Schema, defs spec.Definitions, err error) {
-	parser := tspec.NewParser()
-	parser.Options(t.opts.ParserOptions)
+// load imports the package to parse, the loader is shared by parse calls until Reset, so
+// that types of the same package have the same owner
+func (t *Parser) load(pkgPath string) (pkg *ast.Package, err error) {
+	if t.loader == nil {
+		t.loader = tspec.NewParser()
+		t.fset = token.NewFileSet()
+		t.dirPkgs = make(map[string]*ast.Package)
+		t.pkgPaths = make(map[*ast.Package]string)
+	}
+	t.loader.Options(t.opts.ParserOptions)
+	t.parsed = make(map[string]bool)
+	t.consts = make(map[*ast.Package]map[string][]*goConst)
+	t.methods = make(map[*ast.Package]map[string]map[string]bool)
+	pkg, err = t.importPkg(pkgPath)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	t.root = pkg
+	t.pkgPaths[pkg] = importPath(pkgPath)
+	return
+}
 
-	pkg, err := parser.Import(pkgPath)
+// importPkg imports and parses package as tspec does, files are parsed into the file set of
+// parser so that positions can be resolved
+func (t *Parser) importPkg(pkgPath string) (pkg *ast.Package, err error) {
+	wd, err := os.Getwd()
 	if err != nil {
 		err = errors.WithStack(err)
 		return
 	}
-	def, err = parser.Parse(pkg, typeExpr)
+	bpkg, err := build.Import(pkgPath, wd, build.ImportComment)
 	if err != nil {
 		err = errors.WithStack(err)
 		return
 	}
-	defs = parser.Definitions()
+	if pkg, ok := t.dirPkgs[bpkg.Dir]; ok {
+		return pkg, nil
+	}
+	pkgs, err := parser.ParseDir(t.fset, bpkg.Dir, nil, parser.ParseComments)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	pkg, ok := pkgs[bpkg.Name]
+	if !ok {
+		err = errors.Errorf("%s not found in %s", bpkg.Name, bpkg.Dir)
+		return
+	}
+	t.dirPkgs[bpkg.Dir] = pkg
 	return
 }
 
+// importPath resolves package path relative to working dir into import path
+func importPath(pkgPath string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return pkgPath
+	}
+	p, err := build.Import(pkgPath, wd, build.FindOnly)
+	if err != nil {
+		return pkgPath
+	}
+	return p.ImportPath
+}
+
 // ParseProtoFile parses proto file
 func ParseProtoFile(path string) (p *proto.Proto, err error) {
 	reader, err := os.Open(path)
@@ -266,37 +1219,147 @@ func ParseProtoFile(path string) (p *proto.Proto, err error) {
 	return
 }
 
-const (
-	emptyType = "empty"
-)
-
-func schemaTypeStr(schema *spec.Schema) string {
-	if len(schema.Type) == 0 && schema.Ref.String() == "" {
-		return emptyType
+func messageFieldTypes(msg *proto.Message) (types []string) {
+	for _, each := range msg.Elements {
+		switch f := each.(type) {
+		case *proto.NormalField:
+			types = append(types, f.Type)
+		case *proto.MapField:
+			types = append(types, f.Type)
+		case *proto.Oneof:
+			for _, e := range f.Elements {
+				if of, ok := e.(*proto.OneOfField); ok {
+					types = append(types, of.Type)
+				}
+			}
+		}
 	}
+	return
+}
 
-	l := make([]string, 0, 2)
-	if len(schema.Type) > 0 {
-		l = append(l, schema.Type[0])
-	} else {
-		l = append(l, schema.Ref.String()[len(tspecRefPrefix):])
+// structTypeSpec returns the type spec declaring struct, nil for anonymous structs
+func (t *Parser) structTypeSpec(pkg *ast.Package, st *ast.StructType) *ast.TypeSpec {
+	objs, err := t.loader.ParsePkg(pkg)
+	if err != nil {
+		return nil
 	}
-	if schema.Format != "" {
-		l = append(l, schema.Format)
+	for _, obj := range objs {
+		ts, err := objDeclTypeSpec(obj)
+		if err == nil && starExprX(ts.Type) == st {
+			return ts
+		}
 	}
-	return strings.Join(l, ":")
+	return nil
 }
 
-func schemaAllProperties(schema *spec.Schema) (props map[string]*spec.Schema) {
-	props = make(map[string]*spec.Schema)
-	for _, s := range schema.AllOf {
-		for k, v := range schemaAllProperties(&s) {
-			props[k] = v
+// messageOptionNames returns names of options of message, its fields and oneofs
+func messageOptionNames(msg *proto.Message) (names []string) {
+	for _, each := range msg.Elements {
+		switch v := each.(type) {
+		case *proto.Option:
+			names = append(names, v.Name)
+		case *proto.Oneof:
+			for _, e := range v.Elements {
+				if opt, ok := e.(*proto.Option); ok {
+					names = append(names, opt.Name)
+				}
+			}
+		}
+		for _, f := range visiteeFields(each) {
+			for _, opt := range f.Options {
+				names = append(names, opt.Name)
+			}
 		}
 	}
-	for k, v := range schema.Properties {
-		vc := v
-		props[k] = &vc
+	return
+}
+
+func starExprX(expr ast.Expr) ast.Expr {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		return star.X
+	}
+	return expr
+}
+
+func objDeclTypeSpec(obj *ast.Object) (ts *ast.TypeSpec, err error) {
+	ts, ok := obj.Decl.(*ast.TypeSpec)
+	if !ok {
+		err = errors.Errorf("invalid object decl, want *ast.TypeSpec, got %T", obj.Decl)
+		return
+	}
+	return
+}
+
+func selectorExprTypeStr(expr *ast.SelectorExpr) string {
+	if xIdent, ok := expr.X.(*ast.Ident); ok {
+		return xIdent.Name + "." + expr.Sel.Name
+	}
+	return expr.Sel.Name
+}
+
+// identStructType returns the struct type of ident returned by underlyingType
+func identStructType(expr ast.Expr) (st *ast.StructType, ok bool) {
+	ident, ok := expr.(*ast.Ident)
+	if !ok || ident.Obj == nil {
+		return nil, false
+	}
+	ts, err := objDeclTypeSpec(ident.Obj)
+	if err != nil {
+		return nil, false
+	}
+	st, ok = starExprX(ts.Type).(*ast.StructType)
+	return
+}
+
+// isEmptyInterface checks whether expr is interface{} or any
+func isEmptyInterface(expr ast.Expr) bool {
+	switch typ := expr.(type) {
+	case *ast.InterfaceType:
+		return typ.Methods == nil || len(typ.Methods.List) == 0
+	case *ast.Ident:
+		return typ.Name == "any" && typ.Obj == nil
+	}
+	return false
+}
+
+func isByteIdent(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && (ident.Name == "byte" || ident.Name == "uint8")
+}
+
+func eltTitle(elt ast.Expr, typeTitle string) string {
+	if _, isAnonymousStruct := starExprX(elt).(*ast.StructType); isAnonymousStruct {
+		return typeTitle + "_Elt"
+	}
+	return ""
+}
+
+// fieldTagList defines tags read from struct fields, json, required and description keep the
+// meaning they have in tspec schemas
+var fieldTagList = []string{"json", FieldTagName, "description", ValidateTagName, RequiredTagName}
+
+// fieldName returns the name of struct field, or the type name of embedded field
+func fieldName(field *ast.Field) string {
+	if len(field.Names) != 0 {
+		return field.Names[0].Name
+	}
+	switch typ := starExprX(field.Type).(type) {
+	case *ast.Ident:
+		return typ.Name
+	case *ast.SelectorExpr:
+		return typ.Sel.Name
+	}
+	return ""
+}
+
+func parseFieldTag(field *ast.Field) (tags map[string]string) {
+	tags = make(map[string]string)
+	if field.Tag == nil {
+		return
+	}
+	stag := reflect.StructTag(strings.Trim(field.Tag.Value, "`"))
+	for _, k := range fieldTagList {
+		tags[k] = stag.Get(k)
 	}
 	return
 }
@@ -2,11 +2,18 @@ package tproto_test
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/emicklei/proto"
+	"github.com/go-openapi/spec"
 	"github.com/stretchr/testify/suite"
 	"github.com/wy-z/tproto/samples"
 	"github.com/wy-z/tproto/tproto"
+	"github.com/wy-z/tspec/tspec"
 )
 
 func TestTProto(t *testing.T) {
@@ -23,6 +30,7 @@ func (s *TProtoTestSuite) SetupTest() {
 	s.parser = tproto.NewParser()
 	parserOpts := tproto.DefaultParserOptions
 	parserOpts.IgnoreJSONTag = true
+	parserOpts.Decorator = "@service"
 	s.parser.Options(parserOpts)
 	s.pkg = "github.com/wy-z/tproto/samples"
 }
@@ -50,4 +58,549 @@ func (s *TProtoTestSuite) TestParse() {
 	s.testParse("StructWithAnonymousField", "source/struct_with_anonymous_field.proto")
 	s.testParse("StructWithCircularReference", "source/struct_with_circular_reference.proto")
 	s.testParse("StructWithInheritance", "source/struct_with_inheritance.proto")
+	s.testParse("StructWithTimeTypes", "source/struct_with_time_types.proto")
+	s.testParse("StructWithPointerFields", "source/struct_with_pointer_fields.proto")
+	s.testParse("StructWithEnums", "source/struct_with_enums.proto")
+	s.testParse("StructWithOneof", "source/struct_with_oneof.proto")
+	s.testParse("StructWithSharedOneof", "source/struct_with_shared_oneof.proto")
+	s.testParse("StructWithInterfaces", "source/struct_with_interfaces.proto")
+	s.testParse("StructWithDynamicFields", "source/struct_with_dynamic_fields.proto")
+	s.testParse("StructWithMapKeys", "source/struct_with_map_keys.proto")
+	s.testParse("StructWithNestedCollections", "source/struct_with_nested_collections.proto")
+	s.testParse("StructWithShadowedField", "source/struct_with_shadowed_field.proto")
+	s.testParse("StructWithAliasedImports", "source/struct_with_aliased_imports.proto")
+}
+
+func (s *TProtoTestSuite) TestParseUnsignedAsSigned() {
+	parserOpts := s.parser.Options()
+	parserOpts.UnsignedAsSigned = true
+	s.parser.Options(parserOpts)
+	s.testParse("BasicTypes", "source/basic_types_unsigned_as_signed.proto")
+}
+
+func (s *TProtoTestSuite) TestParseTimeWellKnownTypes() {
+	parserOpts := s.parser.Options()
+	parserOpts.TimeWellKnownTypes = true
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithTimeTypes", "source/struct_with_time_types_well_known.proto")
+	s.testParse("StructWithNoExportField", "source/struct_with_no_export_field_well_known.proto")
+	s.testParse("StructWithAliasedImports", "source/struct_with_aliased_imports_well_known.proto")
+}
+
+func (s *TProtoTestSuite) TestParsePresence() {
+	parserOpts := s.parser.Options()
+	parserOpts.Presence = tproto.PresenceOptional
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithPointerFields", "source/struct_with_pointer_fields_optional.proto")
+	s.testParse("StructWithPointerEnumFields", "source/struct_with_pointer_enum_fields_optional.proto")
+
+	parserOpts.Presence = tproto.PresenceWrapper
+	parserOpts.OmitEmptyPresence = true
+	parserOpts.IgnoreJSONTag = false
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithPointerFields", "source/struct_with_pointer_fields_wrapper.proto")
+}
+
+func (s *TProtoTestSuite) TestParseDynamicTypes() {
+	// default dynamic types can't be changed by callers
+	dynamicTypes := tproto.DefaultDynamicTypes()
+	dynamicTypes[tproto.DynamicInterface] = ""
+	s.Equal("google.protobuf.Value", tproto.DefaultDynamicTypes()[tproto.DynamicInterface])
+	s.Nil(tproto.DefaultParserOptions.DynamicTypes)
+
+	parserOpts := s.parser.Options()
+	parserOpts.DynamicTypes = map[string]string{
+		tproto.DynamicInterface: "google.protobuf.Any",
+		tproto.DynamicRawJSON:   "google.protobuf.Value",
+	}
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithDynamicFields", "source/struct_with_dynamic_fields_custom.proto")
+}
+
+func (s *TProtoTestSuite) TestParseEmbedding() {
+	_, err := s.parser.Parse(s.pkg, "StructWithEmbeddedConflict")
+	s.Require().Error(err)
+	s.Contains(err.Error(), "Name is defined by both Person and Company")
+	s.parser.Reset()
+
+	parserOpts := s.parser.Options()
+	parserOpts.Embedding = tproto.EmbedCompose
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithInheritance", "source/struct_with_inheritance_compose.proto")
+}
+
+func (s *TProtoTestSuite) TestParseStableNumbers() {
+	require := s.Require()
+
+	require.NoError(s.parser.LoadProtoFile("../samples/source/struct_with_stable_numbers_previous.proto"))
+	s.testParse("StructWithStableNumbers", "source/struct_with_stable_numbers.proto")
+
+	// values of string enums are numbered in declaration order unless kept
+	require.NoError(s.parser.LoadProtoFile("../samples/source/struct_with_enums_previous.proto"))
+	_, err := s.parser.Parse(s.pkg, "StructWithEnums")
+	require.NoError(err)
+	s.Equal(string(bytes.TrimSpace(samples.MustAsset("source/struct_with_stable_enums.proto"))),
+		string(bytes.TrimSpace(s.parser.RenderProto(samplesProtoPkg).Bytes())))
+	s.Equal(map[string]int{"ColorUnspecified": 0, "ColorBlue": 1, "ColorRed": 2, "ColorGreen": 5},
+		s.parser.SchemaLock().Enums["Color"].Values)
+
+	dir, err := ioutil.TempDir("", "tproto")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, tproto.DefaultLockFile)
+	require.NoError(s.parser.WriteLockFile(path))
+	s.parser.Reset()
+	require.NoError(s.parser.LoadLockFile(path))
+	s.testParse("StructWithEnums", "source/struct_with_stable_enums.proto")
+}
+
+func (s *TProtoTestSuite) TestParseEnumValueConflicts() {
+	_, err := s.parser.Parse(s.pkg, "StructWithTakenEnumZeroValue")
+	s.Error(err)
+	s.Contains(err.Error(), "enum value StageUnspecified of Stage conflicts with the generated zero value")
+	s.parser.Reset()
+
+	_, err = s.parser.Parse(s.pkg, "StructWithForeignEnumValues")
+	s.Error(err)
+	s.Contains(err.Error(), "enum value StateOpen of github.com/wy-z/tproto/samples/auth.SessionState "+
+		"conflicts with github.com/wy-z/tproto/samples/billing.State")
+	s.parser.Reset()
+
+	// values of nested enums are scoped by their namespaces
+	parserOpts := s.parser.Options()
+	parserOpts.Namespace = tproto.NamespaceNested
+	s.parser.Options(parserOpts)
+	_, err = s.parser.Parse(s.pkg, "StructWithForeignEnumValues")
+	s.NoError(err)
+}
+
+func (s *TProtoTestSuite) TestParseFieldTags() {
+	s.testParse("StructWithFieldTags", "source/struct_with_field_tags.proto")
+
+	_, err := s.parser.Parse(s.pkg, "StructWithDuplicateFieldNumbers")
+	s.Require().Error(err)
+	s.Contains(err.Error(), "duplicate field number 1 in StructWithDuplicateFieldNumbers: First and Second")
+	s.parser.Reset()
+
+	_, err = s.parser.Parse(s.pkg, "StructWithReservedFieldNumber")
+	s.Require().Error(err)
+	s.Contains(err.Error(), "invalid tproto tag of StructWithReservedFieldNumber.Field")
+	s.parser.Reset()
+
+	s.Require().NoError(s.parser.LoadProtoFile("../samples/source/struct_with_reserved_field_tag_previous.proto"))
+	_, err = s.parser.Parse(s.pkg, "StructWithReservedFieldTag")
+	s.Require().Error(err)
+	s.Contains(err.Error(), "field number 2 of StructWithReservedFieldTag.Phone is reserved")
+	s.parser.Reset()
+}
+
+func (s *TProtoTestSuite) TestCheck() {
+	require := s.Require()
+
+	existing, err := tproto.ParseProtoFile("../samples/source/normal_struct_check_existing.proto")
+	require.NoError(err)
+	_, err = s.parser.Parse(s.pkg, "NormalStruct")
+	require.NoError(err)
+	report := s.parser.Check(existing)
+	s.True(report.Breaking)
+	s.Equal([]*tproto.BreakingChange{
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleMessageRenamed, Message: "Basics",
+			Old: "Basics", New: "BasicTypes"},
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleFieldRenumbered, Message: "NormalStruct",
+			Field: "BasicTypes", Old: "5", New: "1"},
+		{Kind: tproto.ChangeJSON, Rule: tproto.RuleFieldRenamed, Message: "NormalStruct",
+			Field: "CreatedAt", Old: "CreatedAt", New: "Create"},
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleFieldTypeChanged, Message: "NormalStruct",
+			Field: "Number", Old: "int32", New: "int64"},
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleFieldRemoved, Message: "NormalStruct",
+			Field: "Comment", Old: "4"},
+	}, report.Changes)
+	s.parser.Reset()
+
+	existing, err = tproto.ParseProtoFile("../samples/source/normal_struct.proto")
+	require.NoError(err)
+	_, err = s.parser.Parse(s.pkg, "NormalStruct")
+	require.NoError(err)
+	report = s.parser.Check(existing)
+	s.False(report.Breaking)
+	s.Empty(report.Changes)
+	s.parser.Reset()
+
+	// structurally identical messages are not paired by guess
+	existing, err = tproto.ParseProtoFile("../samples/source/struct_with_enums_check_existing.proto")
+	require.NoError(err)
+	_, err = s.parser.Parse(s.pkg, "StructWithEnums")
+	require.NoError(err)
+	report = s.parser.Check(existing)
+	s.True(report.Breaking)
+	s.Equal([]*tproto.BreakingChange{
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleMessageRenameAmbiguous, Message: "EnumStruct",
+			Old: "EnumStruct", New: "StructWithEnums"},
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleMessageRenameAmbiguous, Message: "StructOfEnums",
+			Old: "StructOfEnums", New: "StructWithEnums"},
+		{Kind: tproto.ChangeJSON, Rule: tproto.RuleEnumValueRenamed, Enum: "Priority",
+			Field: "PriorityNormal", Old: "PriorityNormal", New: "PriorityMedium"},
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleEnumValueRenumbered, Enum: "Status",
+			Field: "StatusActive", Old: "2", New: "1"},
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleEnumValueRenumbered, Enum: "Status",
+			Field: "StatusInactive", Old: "1", New: "2"},
+		{Kind: tproto.ChangeWire, Rule: tproto.RuleEnumValueRemoved, Enum: "Status",
+			Field: "StatusArchived", Old: "4"},
+	}, report.Changes)
+	s.parser.Reset()
+
+	// fields are numbered by the lockfile as generation does
+	existing, err = tproto.ParseProtoFile("../samples/source/struct_with_stable_numbers.proto")
+	require.NoError(err)
+	_, err = s.parser.Parse(s.pkg, "StructWithStableNumbers")
+	require.NoError(err)
+	report = s.parser.Check(existing)
+	s.True(report.Breaking)
+	s.parser.Reset()
+
+	require.NoError(s.parser.LoadLockFile("../samples/source/struct_with_stable_numbers.lock"))
+	_, err = s.parser.Parse(s.pkg, "StructWithStableNumbers")
+	require.NoError(err)
+	report = s.parser.Check(existing)
+	s.False(report.Breaking)
+	s.Empty(report.Changes)
+	s.parser.Reset()
+}
+
+func (s *TProtoTestSuite) TestWriteFieldTags() {
+	require := s.Require()
+
+	dir, err := ioutil.TempDir("", "tproto")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "types.go")
+	require.NoError(ioutil.WriteFile(path, samples.MustAsset("source/struct_with_written_tags.go.in"), 0644))
+	wd, err := os.Getwd()
+	require.NoError(err)
+	pkgPath, err := filepath.Rel(wd, dir)
+	require.NoError(err)
+
+	_, err = s.parser.Parse(pkgPath, "StructWithWrittenTags")
+	require.NoError(err)
+	_, err = s.parser.Parse(pkgPath, "OtherWithWrittenTags")
+	require.NoError(err)
+	before := s.parser.RenderProto(samplesProtoPkg).String()
+	files, err := s.parser.WriteFieldTags()
+	require.NoError(err)
+	s.Equal([]string{path}, files)
+	src, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	s.Equal(string(samples.MustAsset("source/struct_with_written_tags.go.out")), string(src))
+
+	// numbers are kept by tags, and written tags are stable
+	s.parser.Reset()
+	_, err = s.parser.Parse(pkgPath, "StructWithWrittenTags")
+	require.NoError(err)
+	_, err = s.parser.Parse(pkgPath, "OtherWithWrittenTags")
+	require.NoError(err)
+	s.Equal(before, s.parser.RenderProto(samplesProtoPkg).String())
+	files, err = s.parser.WriteFieldTags()
+	require.NoError(err)
+	s.Empty(files)
+}
+
+func (s *TProtoTestSuite) TestLockFile() {
+	require := s.Require()
+
+	require.NoError(s.parser.LoadLockFile("../samples/source/struct_with_stable_numbers.lock"))
+	s.testParse("StructWithStableNumbers", "source/struct_with_stable_numbers.proto")
+
+	dir, err := ioutil.TempDir("", "tproto")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	require.NoError(s.parser.LoadLockFile("../samples/source/struct_with_stable_numbers.lock"))
+	_, err = s.parser.Parse(s.pkg, "StructWithStableNumbers")
+	require.NoError(err)
+	path := filepath.Join(dir, tproto.DefaultLockFile)
+	require.NoError(s.parser.WriteLockFile(path))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	s.Equal(string(samples.MustAsset("source/struct_with_stable_numbers_updated.lock")), string(data))
+
+	yamlPath := filepath.Join(dir, "tproto.lock.yaml")
+	require.NoError(s.parser.WriteLockFile(yamlPath))
+	lock := s.parser.SchemaLock()
+	require.NoError(s.parser.LoadLockFile(yamlPath))
+	s.Equal(lock, s.parser.SchemaLock())
+}
+
+func (s *TProtoTestSuite) TestParseNaming() {
+	parserOpts := s.parser.Options()
+	parserOpts.SnakeCaseFields = true
+	s.parser.Options(parserOpts)
+	s.testParse("NormalStruct", "source/normal_struct_snake_case.proto")
+
+	parserOpts.CamelCaseMessages = true
+	parserOpts.UpperSnakeEnumValues = true
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithAnonymousField", "source/struct_with_anonymous_field_style_guide.proto")
+	s.testParse("StructWithEnums", "source/struct_with_enums_style_guide.proto")
+}
+
+func (s *TProtoTestSuite) TestParseNamespace() {
+	_, err := s.parser.Parse(s.pkg, "StructWithForeignTypes")
+	s.Error(err)
+	s.Contains(err.Error(), "proto name Account of github.com/wy-z/tproto/samples/billing.Account "+
+		"conflicts with github.com/wy-z/tproto/samples/auth.Account")
+	s.parser.Reset()
+
+	parserOpts := s.parser.Options()
+	parserOpts.Namespace = tproto.NamespacePrefix
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithForeignTypes", "source/struct_with_foreign_types_prefix.proto")
+
+	parserOpts.Namespace = tproto.NamespaceNested
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithForeignTypes", "source/struct_with_foreign_types_nested.proto")
+
+	// namespaces don't merge into messages sharing their names
+	parserOpts.Renames = map[string]string{"github.com/wy-z/tproto/samples.StructWithForeignTypes": "Billing"}
+	s.parser.Options(parserOpts)
+	_, err = s.parser.Parse(s.pkg, "StructWithForeignTypes")
+	s.Error(err)
+	s.Contains(err.Error(), "namespace Billing of github.com/wy-z/tproto/samples/billing.Billing.Account "+
+		"conflicts with github.com/wy-z/tproto/samples.Billing")
+	s.parser.Reset()
+	parserOpts.Renames = map[string]string{"github.com/wy-z/tproto/samples.NormalStruct": "Auth"}
+	s.parser.Options(parserOpts)
+	_, err = s.parser.Parse(s.pkg, "StructWithForeignTypes")
+	s.NoError(err)
+	_, err = s.parser.Parse(s.pkg, "NormalStruct")
+	s.Error(err)
+	s.Contains(err.Error(), "proto name Auth of github.com/wy-z/tproto/samples.Auth "+
+		"conflicts with namespace of github.com/wy-z/tproto/samples/auth.Auth.Account")
+	s.parser.Reset()
+
+	parserOpts.Namespace = tproto.NamespaceNone
+	parserOpts.Renames = map[string]string{"github.com/wy-z/tproto/samples/auth.Account": "User"}
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithForeignTypes", "source/struct_with_foreign_types_renamed.proto")
+}
+
+func (s *TProtoTestSuite) TestRenderProtoFiles() {
+	require := s.Require()
+	parserOpts := s.parser.Options()
+	parserOpts.PackageFiles = true
+	s.parser.Options(parserOpts)
+
+	_, err := s.parser.Parse(s.pkg, "StructWithForeignTypes")
+	require.NoError(err)
+	files, err := s.parser.RenderProtoFiles()
+	require.NoError(err)
+	asserts := map[string]string{
+		"github.com/wy-z/tproto/samples/samples.proto":         "source/package_files_samples.proto",
+		"github.com/wy-z/tproto/samples/auth/auth.proto":       "source/package_files_auth.proto",
+		"github.com/wy-z/tproto/samples/billing/billing.proto": "source/package_files_billing.proto",
+	}
+	require.Len(files, len(asserts))
+	for name, assert := range asserts {
+		require.Contains(files, name)
+		s.Equal(string(bytes.TrimSpace(samples.MustAsset(assert))),
+			string(bytes.TrimSpace(files[name].Bytes())))
+	}
+	s.parser.Reset()
+
+	// the given go_package belongs to the parsed package only
+	parserOpts.FileOptions.GoPackage = "github.com/wy-z/tproto/pb;samplespb"
+	s.parser.Options(parserOpts)
+	_, err = s.parser.Parse(s.pkg, "StructWithForeignTypes")
+	require.NoError(err)
+	files, err = s.parser.RenderProtoFiles()
+	require.NoError(err)
+	s.Contains(files["github.com/wy-z/tproto/samples/samples.proto"].String(),
+		`option go_package = "github.com/wy-z/tproto/pb;samplespb";`)
+	s.Contains(files["github.com/wy-z/tproto/samples/auth/auth.proto"].String(),
+		`option go_package = "github.com/wy-z/tproto/samples/auth/pb";`)
+	s.Contains(files["github.com/wy-z/tproto/samples/billing/billing.proto"].String(),
+		`option go_package = "github.com/wy-z/tproto/samples/billing/pb";`)
+}
+
+func (s *TProtoTestSuite) TestFileOptions() {
+	require := s.Require()
+
+	fileOpts, err := tproto.LoadFileOptions("../samples/source/file_options.yaml")
+	require.NoError(err)
+	parserOpts := s.parser.Options()
+	parserOpts.FileOptions = fileOpts
+	s.parser.Options(parserOpts)
+	s.testParse("NormalStruct", "source/normal_struct_file_options.proto")
+
+	parserOpts.FileOptions = tproto.FileOptions{GoPackage: "github.com/wy-z/tproto/pb;samplespb"}
+	s.parser.Options(parserOpts)
+	_, err = s.parser.Parse(s.pkg, "NormalStruct")
+	require.NoError(err)
+	s.Contains(s.parser.RenderProto(samplesProtoPkg).String(),
+		`option go_package = "github.com/wy-z/tproto/pb;samplespb";`)
+}
+
+func (s *TProtoTestSuite) TestParseComments() {
+	s.testParse("StructWithComments", "source/struct_with_comments.proto")
+	s.testParse("StructWithDeprecations", "source/struct_with_deprecations.proto")
+}
+
+func (s *TProtoTestSuite) TestParseValidateRules() {
+	parserOpts := s.parser.Options()
+	parserOpts.ValidateRules = true
+	parserOpts.Presence = tproto.PresenceWrapper
+	s.parser.Options(parserOpts)
+
+	_, err := s.parser.Parse(s.pkg, "StructWithValidation")
+	s.Require().NoError(err)
+	var rules []string
+	for _, w := range s.parser.ValidateWarnings() {
+		rules = append(rules, w.Field+":"+w.Rule)
+	}
+	s.Equal([]string{"Code:alphanum", "Tags:min=1"}, rules)
+	s.parser.Reset()
+	s.testParse("StructWithValidation", "source/struct_with_validation.proto")
+
+	_, err = s.parser.Parse(s.pkg, "StructWithEnumValidation")
+	s.Require().NoError(err)
+	s.Len(s.parser.ValidateWarnings(), 1)
+	s.Equal("StructWithEnumValidation.Status: validate rule \"oneof=1 4\" is not translated, "+
+		"4 is not a value of Status", s.parser.ValidateWarnings()[0].String())
+	s.parser.Reset()
+	s.testParse("StructWithEnumValidation", "source/struct_with_enum_validation.proto")
+}
+
+func (s *TProtoTestSuite) TestParseService() {
+	require := s.Require()
+
+	service, err := s.parser.ParseService(s.pkg, "UserService")
+	require.NoError(err)
+	require.NotNil(service)
+	require.Equal(string(bytes.TrimSpace(samples.MustAsset("source/user_service.proto"))),
+		string(bytes.TrimSpace(s.parser.RenderProto(samplesProtoPkg).Bytes())))
+	s.parser.Reset()
+
+	_, err = s.parser.ParseService(s.pkg, "ChatService")
+	require.NoError(err)
+	require.Equal(string(bytes.TrimSpace(samples.MustAsset("source/chat_service.proto"))),
+		string(bytes.TrimSpace(s.parser.RenderProto(samplesProtoPkg).Bytes())))
+	s.parser.Reset()
+
+	_, err = s.parser.ParseService(s.pkg, "ServiceWithInvalidMethod")
+	s.Regexp(`types\.go:\d+:2: unsupported signature of method ServiceWithInvalidMethod\.GetUser`, err)
+	_, err = s.parser.ParseService(s.pkg, "ServiceWithInvalidStream")
+	s.Regexp(`types\.go:\d+:2: unsupported signature of method ServiceWithInvalidStream\.ExportUsers`, err)
+	_, err = s.parser.ParseService(s.pkg, "NormalStruct")
+	s.EqualError(err, "unsupported type NormalStruct, want interface")
+	s.parser.Reset()
+
+	// context is resolved by import path
+	_, err = s.parser.ParseService(s.pkg, "ServiceWithAliasedContext")
+	require.NoError(err)
+	s.parser.Reset()
+	_, err = s.parser.ParseService(s.pkg, "ServiceWithLocalContext")
+	s.Regexp(`aliased_imports\.go:\d+:2: unsupported signature of method ServiceWithLocalContext\.GetUser`, err)
+}
+
+func (s *TProtoTestSuite) TestParseHTTPRules() {
+	require := s.Require()
+
+	_, err := s.parser.ParseService(s.pkg, "UserGatewayService")
+	require.NoError(err)
+	require.Equal(string(bytes.TrimSpace(samples.MustAsset("source/user_gateway_service.proto"))),
+		string(bytes.TrimSpace(s.parser.RenderProto(samplesProtoPkg).Bytes())))
+	s.parser.Reset()
+
+	_, err = s.parser.ParseService(s.pkg, "ServiceWithInvalidHTTPRule")
+	s.Regexp(`types\.go:\d+:2: invalid method ServiceWithInvalidHTTPRule\.GetUser: path variable Name is not a field of GetUserRequest`, err)
+	s.parser.Reset()
+
+	_, err = s.parser.ParseService(s.pkg, "ServiceWithRepeatedPathVariable")
+	s.Regexp(`types\.go:\d+:2: invalid method ServiceWithRepeatedPathVariable\.GetUser: path variable Users\.ID is a repeated or map field, want singular`, err)
+}
+
+func (s *TProtoTestSuite) TestParseSyntax() {
+	parserOpts := s.parser.Options()
+	parserOpts.Presence = tproto.PresenceOptional
+	parserOpts.Syntax = tproto.SyntaxProto2
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithRequiredFields", "source/struct_with_required_fields_proto2.proto")
+
+	parserOpts.Syntax = tproto.SyntaxEditions
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithRequiredFields", "source/struct_with_required_fields_editions.proto")
+
+	// required fields of nested messages
+	parserOpts.Namespace = tproto.NamespaceNested
+	parserOpts.Syntax = tproto.SyntaxProto2
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithForeignRequiredFields", "source/struct_with_foreign_required_fields_proto2.proto")
+
+	parserOpts.Syntax = tproto.SyntaxEditions
+	s.parser.Options(parserOpts)
+	s.testParse("StructWithForeignRequiredFields", "source/struct_with_foreign_required_fields_editions.proto")
+}
+
+// tspecFields collects property names and required properties of schema, allOf schemas
+// referencing embedded structs are flattened
+func tspecFields(defs spec.Definitions, schema spec.Schema, names, required map[string]bool) {
+	for _, s := range schema.AllOf {
+		if ref := s.Ref.String(); ref != "" {
+			s = defs[strings.TrimPrefix(ref, "#/")]
+		}
+		tspecFields(defs, s, names, required)
+	}
+	for name := range schema.Properties {
+		names[name] = true
+	}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+}
+
+// TestTSpecParity checks that fields and required fields of parsed messages are the same as
+// the properties of tspec schemas, which tproto was built on
+func (s *TProtoTestSuite) TestTSpecParity() {
+	require := s.Require()
+
+	for _, ignoreJSONTag := range []bool{true, false} {
+		parserOpts := s.parser.Options()
+		parserOpts.IgnoreJSONTag = ignoreJSONTag
+		parserOpts.Syntax = tproto.SyntaxProto2
+		s.parser.Options(parserOpts)
+		tspecParser := tspec.NewParser()
+		tspecParser.Options(tspec.ParserOptions{IgnoreJSONTag: ignoreJSONTag, RefPrefix: "#/"})
+		pkg, err := tspecParser.Import(s.pkg)
+		require.NoError(err)
+
+		for _, typeStr := range []string{"BasicTypes", "NormalStruct", "StructWithNoExportField",
+			"StructWithAnonymousField", "StructWithCircularReference", "StructWithInheritance",
+			"StructWithRequiredFields"} {
+			schema, err := tspecParser.Parse(pkg, typeStr)
+			require.NoError(err)
+			names, required := make(map[string]bool), make(map[string]bool)
+			tspecFields(tspecParser.Definitions(), *schema, names, required)
+
+			message, err := s.parser.Parse(s.pkg, typeStr)
+			require.NoError(err)
+			protoNames, protoRequired := make(map[string]bool), make(map[string]bool)
+			for _, each := range message.Elements {
+				switch f := each.(type) {
+				case *proto.NormalField:
+					protoNames[f.Name] = true
+					if f.Required {
+						protoRequired[f.Name] = true
+					}
+					if f.Repeated {
+						// repeated fields can't be required
+						delete(required, f.Name)
+					}
+				case *proto.MapField:
+					protoNames[f.Name] = true
+					delete(required, f.Name)
+				}
+			}
+			s.Equal(names, protoNames, typeStr)
+			s.Equal(required, protoRequired, typeStr)
+			s.parser.Reset()
+		}
+	}
 }
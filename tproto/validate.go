@@ -0,0 +1,306 @@
+package tproto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emicklei/proto"
+)
+
+// ValidateTagName defines the struct tag of go-playground validator
+const ValidateTagName = "validate"
+
+// validateOption defines the protovalidate field option
+const validateOption = "(buf.validate.field)"
+
+// ValidateWarning defines a rule of validate tag which can't be translated
+type ValidateWarning struct {
+	Type   string `json:"type"`
+	Field  string `json:"field"`
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+func (w *ValidateWarning) String() string {
+	return fmt.Sprintf("%s.%s: validate rule %q is not translated, %s", w.Type, w.Field, w.Rule,
+		w.Reason)
+}
+
+// ValidateWarnings returns rules of validate tags which can't be translated,
+// see ParserOptions.ValidateRules
+func (t *Parser) ValidateWarnings() []*ValidateWarning {
+	return t.validateWarnings
+}
+
+// protoValidateLengthRules maps go-playground length rules to protovalidate rules of strings,
+// bytes, repeated and map fields, values are bounds for min and max
+var protoValidateLengthRules = map[string]map[string]string{
+	"string":   {"min": "min_len", "max": "max_len", "len": "len"},
+	"bytes":    {"min": "min_len", "max": "max_len", "len": "len"},
+	"repeated": {"min": "min_items", "max": "max_items"},
+	"map":      {"min": "min_pairs", "max": "max_pairs"},
+}
+
+// protoValidateNumberRules maps go-playground rules to protovalidate rules of numbers
+var protoValidateNumberRules = map[string]string{
+	"min": "gte", "max": "lte", "gte": "gte", "lte": "lte", "gt": "gt", "lt": "lt",
+	"eq": "const", "len": "const",
+}
+
+// protoValidateStringRules maps go-playground format and substring rules to protovalidate
+// rules of strings, format rules don't have parameters
+var protoValidateStringRules = map[string]string{
+	"email": "email", "uuid": "uuid", "uri": "uri", "url": "uri", "hostname": "hostname",
+	"ip": "ip", "ipv4": "ipv4", "ipv6": "ipv6",
+	"contains": "contains", "startswith": "prefix", "endswith": "suffix", "eq": "const",
+}
+
+// setFieldValidation translates validate tag of golang field into protovalidate options,
+// rules which can't be translated are reported by ValidateWarnings
+func (t *Parser) setFieldValidation(fieldProto proto.Visitee, field *goField) {
+	if !t.opts.ValidateRules || field.validate == "" || field.validate == "-" {
+		return
+	}
+	warn := func(rule, reason string) {
+		t.validateWarnings = append(t.validateWarnings, &ValidateWarning{
+			Type: field.owner, Field: field.name, Rule: rule, Reason: reason})
+	}
+
+	var options []*proto.Option
+	var kind string
+	var enum *proto.Enum
+	switch v := fieldProto.(type) {
+	case *proto.NormalField:
+		kind = v.Type
+		if scalarType, ok := wrappedScalarType(v.Type); ok {
+			kind = scalarType
+		}
+		if e, ok := t.enums[v.Type]; ok {
+			kind, enum = "enum", e
+		}
+		if v.Repeated {
+			kind = "repeated"
+		}
+	case *proto.MapField:
+		kind = "map"
+	case *proto.Oneof:
+		kind = "oneof"
+	}
+
+	rules := strings.Split(field.validate, ",")
+	for i, rule := range rules {
+		name, param := rule, ""
+		if j := strings.Index(rule, "="); j >= 0 {
+			name, param = rule[:j], rule[j+1:]
+		}
+		if name == "dive" {
+			for _, r := range rules[i+1:] {
+				warn(r, "rules of elements are not supported")
+			}
+			break
+		}
+
+		switch {
+		case strings.Contains(rule, "|"):
+			warn(rule, "or-ed rules are not supported")
+		case name == "omitempty" && kind == "oneof":
+		case name == "omitempty":
+			options = append(options, &proto.Option{Name: validateOption + ".ignore",
+				Constant: proto.Literal{Source: "IGNORE_IF_ZERO_VALUE"}, IsEmbedded: true})
+		case name == "required" && kind == "oneof":
+			oneof := fieldProto.(*proto.Oneof)
+			oneof.Elements = append([]proto.Visitee{&proto.Option{
+				Name:     "(buf.validate.oneof).required",
+				Constant: proto.Literal{Source: "true"},
+			}}, oneof.Elements...)
+		case name == "required":
+			options = append(options, &proto.Option{Name: validateOption + ".required",
+				Constant: proto.Literal{Source: "true"}, IsEmbedded: true})
+		default:
+			var opts []*proto.Option
+			var reason string
+			if kind == "enum" {
+				opts, reason = t.enumRuleOptions(enum, name, param)
+			} else {
+				opts, reason = validateRuleOptions(kind, name, param)
+			}
+			if reason != "" {
+				warn(rule, reason)
+				continue
+			}
+			options = append(options, opts...)
+		}
+	}
+
+	if kind == "oneof" {
+		return
+	}
+	f := visiteeFields(fieldProto)[0]
+	f.Options = append(f.Options, options...)
+	return
+}
+
+// validateRuleOptions translates a go-playground rule of field kind, which is a proto scalar
+// type, repeated or map, into protovalidate options. It returns the reason if it can't
+func validateRuleOptions(kind, name, param string) (options []*proto.Option, reason string) {
+	option := func(rule, value string) {
+		options = append(options, &proto.Option{
+			Name:       fmt.Sprintf("%s.%s.%s", validateOption, kind, rule),
+			Constant:   proto.Literal{Source: value},
+			IsEmbedded: true,
+		})
+	}
+
+	switch kind {
+	case "string", "bytes", "repeated", "map":
+		if lengthRules, ok := protoValidateLengthRules[kind]; ok {
+			n, e := strconv.ParseUint(param, 10, 64)
+			switch name {
+			case "min", "max", "len", "gte", "lte", "gt", "lt":
+				if e != nil {
+					reason = fmt.Sprintf("invalid length %s", param)
+					return
+				}
+			}
+			switch name {
+			case "gte":
+				name = "min"
+			case "lte":
+				name = "max"
+			case "gt":
+				name, n = "min", n+1
+			case "lt":
+				if n == 0 {
+					reason = "no length is less than 0"
+					return
+				}
+				name, n = "max", n-1
+			}
+			if rule, ok := lengthRules[name]; ok {
+				option(rule, strconv.FormatUint(n, 10))
+				return
+			}
+			if name == "len" {
+				option(lengthRules["min"], param)
+				option(lengthRules["max"], param)
+				return
+			}
+		}
+		if kind == "repeated" && name == "unique" {
+			option("unique", "true")
+			return
+		}
+		if kind != "string" {
+			break
+		}
+		if name == "oneof" {
+			for _, value := range strings.Fields(param) {
+				option("in", strconv.Quote(strings.Trim(value, "'")))
+			}
+			return
+		}
+		// ne compares with the whole param
+		if name == "ne" {
+			option("not_in", strconv.Quote(param))
+			return
+		}
+		if rule, ok := protoValidateStringRules[name]; ok {
+			if param == "" {
+				option(rule, "true")
+			} else {
+				option(rule, strconv.Quote(param))
+			}
+			return
+		}
+	case "bool":
+		if name == "eq" && (param == "true" || param == "false") {
+			option("const", param)
+			return
+		}
+	default:
+		if !protoScalarTypes[kind] {
+			reason = fmt.Sprintf("rules of %s are not supported", kind)
+			return
+		}
+		// only oneof takes a list of values
+		values := strings.Fields(param)
+		if name != "oneof" && len(values) > 1 {
+			values = []string{param}
+		}
+		for _, value := range values {
+			var e error
+			switch kind {
+			case "float", "double":
+				_, e = strconv.ParseFloat(value, 64)
+			case "uint32", "uint64", "fixed32", "fixed64":
+				_, e = strconv.ParseUint(value, 10, 64)
+			default:
+				_, e = strconv.ParseInt(value, 10, 64)
+			}
+			if e != nil {
+				reason = fmt.Sprintf("invalid %s %s", kind, value)
+				return
+			}
+		}
+		if rule, ok := protoValidateNumberRules[name]; ok && len(values) == 1 {
+			option(rule, values[0])
+			return
+		}
+		if name == "oneof" || name == "ne" {
+			rule := "in"
+			if name == "ne" {
+				rule = "not_in"
+			}
+			for _, value := range values {
+				option(rule, value)
+			}
+			return
+		}
+	}
+	reason = fmt.Sprintf("no protovalidate rule of %s", kind)
+	return
+}
+
+// protoValidateEnumRules maps go-playground rules to protovalidate rules of enums
+var protoValidateEnumRules = map[string]string{"oneof": "in", "ne": "not_in", "eq": "const"}
+
+// enumRuleOptions translates a go-playground rule of enum field into protovalidate options,
+// values are golang constant values, which are mapped to numbers of enum values. It returns
+// the reason if it can't
+func (t *Parser) enumRuleOptions(enum *proto.Enum, name, param string) (options []*proto.Option,
+	reason string) {
+	rule, ok := protoValidateEnumRules[name]
+	if !ok {
+		reason = "no protovalidate rule of enum"
+		return
+	}
+	values := strings.Fields(param)
+	if name != "oneof" {
+		values = []string{param}
+	}
+	for _, value := range values {
+		n, ok := t.enumValues[enum.Name][strings.Trim(value, "'")]
+		if !ok {
+			reason = fmt.Sprintf("%s is not a value of %s", value, enum.Name)
+			return
+		}
+		options = append(options, &proto.Option{
+			Name:       fmt.Sprintf("%s.enum.%s", validateOption, rule),
+			Constant:   proto.Literal{Source: strconv.Itoa(n)},
+			IsEmbedded: true,
+		})
+	}
+	return
+}
+
+// wrappedScalarType returns the scalar type wrapped by google.protobuf wrapper type,
+// protovalidate applies scalar rules to wrapped values
+func wrappedScalarType(typeStr string) (scalarType string, ok bool) {
+	for scalarType, wrapperType := range protoWrapperTypeMap {
+		if wrapperType == typeStr {
+			return scalarType, true
+		}
+	}
+	return
+}